@@ -1,8 +1,16 @@
 package main
 
 import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Kost0/L3/internal/aggregator"
 	"github.com/Kost0/L3/internal/handlers"
+	"github.com/Kost0/L3/internal/preview"
+	"github.com/Kost0/L3/internal/privacy"
 	"github.com/Kost0/L3/internal/repository"
+	"github.com/Kost0/L3/internal/visitor"
 	"github.com/gin-contrib/cors"
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/zlog"
@@ -24,8 +32,34 @@ func main() {
 
 	zlog.Logger.Info().Msg("DB started")
 
+	retentionDays := 0
+	if value := os.Getenv("RETENTION_DAYS"); value != "" {
+		retentionDays, err = strconv.Atoi(value)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Фоновый пересчет почасовых и дневных агрегатов и удаление старых переходов
+	rollups := aggregator.NewAggregator(db, time.Minute, retentionDays)
+
+	go rollups.Start()
+
+	salts := visitor.NewSaltStore(db)
+
+	anonymizer, err := privacy.NewAnonymizer(os.Getenv("IP_MODE"), salts)
+	if err != nil {
+		panic(err)
+	}
+
 	handler := handlers.Handler{
-		DB: db,
+		DB:         db,
+		Salts:      salts,
+		Anonymizer: anonymizer,
+		Preview:    preview.NewFetcher(),
+
+		RetentionDays: retentionDays,
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
 	}
 
 	engine := ginext.New()
@@ -33,15 +67,23 @@ func main() {
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"http://localhost:5000"},
 		AllowMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
-		AllowHeaders: []string{"Origin", "Accept", "Content-Type"},
+		AllowHeaders: []string{"Origin", "Accept", "Content-Type", "Authorization", handlers.OwnerTokenHeader},
 	}))
 
 	engine.POST("/shorten", handler.URLShortening)
 
 	engine.GET("/s/:short_url", handler.GoShortURL)
 
+	// HEAD-запросы присылают сервисы превью, они учитываются как переходы ботов
+	engine.HEAD("/s/:short_url", handler.GoShortURL)
+
 	engine.GET("/analytics/:short_url/:group", handler.Analytics)
 
+	engine.GET("/analytics/:short_url/export", handler.ExportClicks)
+
+	// Удалить переходы может владелец ссылки или администратор
+	engine.DELETE("/analytics/:short_url/clicks", handler.EraseClicks)
+
 	// Запускаем сервер
 	err = engine.Run(":8080")
 	if err != nil {
@@ -0,0 +1,89 @@
+package aggregator
+
+import (
+	"time"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+type Aggregator struct {
+	DB            *dbpg.DB
+	Interval      time.Duration
+	RetentionDays int
+	rollups       []repository.Rollup
+}
+
+func NewAggregator(db *dbpg.DB, interval time.Duration, retentionDays int) *Aggregator {
+	return &Aggregator{
+		DB:            db,
+		Interval:      interval,
+		RetentionDays: retentionDays,
+		rollups:       []repository.Rollup{repository.HourlyRollup, repository.DailyRollup},
+	}
+}
+
+// Start пересчитывает агрегаты при запуске и затем каждые Interval.
+// Если задан RetentionDays, после пересчета удаляются старые сырые переходы.
+func (a *Aggregator) Start() {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		a.refresh()
+
+		<-ticker.C
+	}
+}
+
+func (a *Aggregator) refresh() {
+	// Переходы до самого раннего из водяных знаков уже учтены во всех агрегатах
+	rolledUpUntil := time.Time{}
+	rolledUp := true
+
+	for _, rollup := range a.rollups {
+		watermark, err := repository.GetRollupWatermark(a.DB, rollup)
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error getting watermark for %s: %v", rollup.Table, err)
+			rolledUp = false
+			continue
+		}
+
+		err = repository.RefreshRollup(a.DB, rollup, watermark)
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error refreshing %s: %v", rollup.Table, err)
+			rolledUp = false
+			continue
+		}
+
+		watermark, err = repository.GetRollupWatermark(a.DB, rollup)
+		if err != nil {
+			rolledUp = false
+			continue
+		}
+
+		if rolledUpUntil.IsZero() || watermark.Before(rolledUpUntil) {
+			rolledUpUntil = watermark
+		}
+	}
+
+	if a.RetentionDays > 0 && rolledUp {
+		a.applyRetention(rolledUpUntil)
+	}
+}
+
+func (a *Aggregator) applyRetention(rolledUpUntil time.Time) {
+	before := time.Now().UTC().AddDate(0, 0, -a.RetentionDays)
+	if rolledUpUntil.Before(before) {
+		before = rolledUpUntil
+	}
+
+	deleted, err := repository.DeleteClicksBefore(a.DB, before)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error deleting old clicks: %v", err)
+		return
+	}
+
+	zlog.Logger.Info().Msgf("Retention: %d clicks older than %s deleted", deleted, before.Format(time.RFC3339))
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+)
+
+// OwnerTokenHeader — заголовок с токеном, выданным при создании ссылки
+const OwnerTokenHeader = "X-Owner-Token"
+
+// authorizeOwner пропускает владельца ссылки с токеном из OwnerTokenHeader или администратора
+// с AdminToken в заголовке Authorization. Иначе отвечает ошибкой и возвращает false.
+func (h *Handler) authorizeOwner(c *ginext.Context, linkID uuid.UUID) bool {
+	if h.isAdmin(c) {
+		return true
+	}
+
+	token := c.GetHeader(OwnerTokenHeader)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, ginext.H{"error": "owner token required"})
+		return false
+	}
+
+	ok, err := repository.OwnerTokenMatches(h.DB, linkID, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return false
+	}
+
+	if !ok {
+		c.JSON(http.StatusForbidden, ginext.H{"error": "invalid owner token"})
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) isAdmin(c *ginext.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || h.AdminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) == 1
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	rollupThreshold = 7 * 24 * time.Hour
+
+	exportBatchSize    = 1000
+	exportDefaultLimit = 10000
+	exportMaxLimit     = 100000
+
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+// useRollups решает, читать ли агрегаты вместо сырых переходов: на больших интервалах это быстрее,
+// а старше срока хранения сырых переходов уже нет, и короткий интервал там есть только в агрегатах
+func (h *Handler) useRollups(from, to time.Time) bool {
+	if to.Sub(from) > rollupThreshold {
+		return true
+	}
+
+	return h.RetentionDays > 0 && from.Before(time.Now().UTC().AddDate(0, 0, -h.RetentionDays))
+}
+
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t.UTC(), nil
+	}
+
+	t, err = time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC3339 or YYYY-MM-DD", value)
+	}
+
+	return t, nil
+}
+
+// parseTimeRange читает параметры from и to, по умолчанию берется все время до текущего момента
+func parseTimeRange(c *ginext.Context) (time.Time, time.Time, error) {
+	from := time.Unix(0, 0).UTC()
+	to := time.Now().UTC()
+
+	var err error
+
+	if value := c.Query("from"); value != "" {
+		from, err = parseTime(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if value := c.Query("to"); value != "" {
+		to, err = parseTime(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+
+	return from, to, nil
+}
+
+func encodeCursor(cursor repository.ClickCursor) string {
+	raw := fmt.Sprintf("%d:%s", cursor.Time.UnixNano(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(value string) (repository.ClickCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return repository.ClickCursor{}, errors.New("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return repository.ClickCursor{}, errors.New("invalid cursor")
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return repository.ClickCursor{}, errors.New("invalid cursor")
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return repository.ClickCursor{}, errors.New("invalid cursor")
+	}
+
+	return repository.ClickCursor{Time: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// ExportClicks отдает сырые переходы в CSV.
+// Строки пишутся пачками по мере чтения из базы, курсор следующей страницы
+// передается в trailer-заголовке X-Next-Cursor, если лимит был исчерпан.
+func (h *Handler) ExportClicks(c *ginext.Context) {
+	shortURL := c.Param("short_url")
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	limit := exportDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > exportMaxLimit {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("limit must be between 1 and %d", exportMaxLimit)})
+			return
+		}
+	}
+
+	cursor := repository.ClickCursor{Time: from, ID: uuid.Nil}
+	if value := c.Query("cursor"); value != "" {
+		cursor, err = decodeCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
+	linkID, _, err := repository.GetLongURL(h.DB, shortURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if linkID == uuid.Nil {
+		c.JSON(http.StatusNotFound, ginext.H{"error": "link not found"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", shortURL+".csv"))
+	c.Header("Trailer", nextCursorHeader)
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+
+	err = writer.Write([]string{"id", "link_id", "time", "user_agent", "ip", "visitor_id", "is_bot"})
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error writing csv: %v", err)
+		return
+	}
+
+	remaining := limit
+
+	for remaining > 0 {
+		batch := min(exportBatchSize, remaining)
+		written := 0
+
+		err = repository.StreamInfo(h.DB, linkID, cursor, to, batch, func(info *repository.URLInfo) error {
+			written++
+			cursor = repository.ClickCursor{Time: info.Time, ID: *info.UUID}
+
+			return writer.Write([]string{
+				info.UUID.String(),
+				info.LinkID.String(),
+				info.Time.Format(time.RFC3339Nano),
+				info.UserAgent,
+				info.IP,
+				info.VisitorID,
+				strconv.FormatBool(info.IsBot),
+			})
+		})
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error exporting clicks: %v", err)
+			return
+		}
+
+		writer.Flush()
+		c.Writer.Flush()
+
+		if written < batch {
+			zlog.Logger.Info().Msg("Clicks exported")
+			return
+		}
+
+		remaining -= written
+	}
+
+	c.Writer.Header().Set(nextCursorHeader, encodeCursor(cursor))
+
+	zlog.Logger.Info().Msg("Clicks page exported")
+}
@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Kost0/L3/internal/preview"
+	"github.com/Kost0/L3/internal/privacy"
 	"github.com/Kost0/L3/internal/repository"
+	"github.com/Kost0/L3/internal/visitor"
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/ginext"
@@ -16,11 +20,19 @@ import (
 )
 
 type Handler struct {
-	DB *dbpg.DB
+	DB         *dbpg.DB
+	Salts      *visitor.SaltStore
+	Anonymizer *privacy.Anonymizer
+	Preview    *preview.Fetcher
+	// RetentionDays — сколько дней хранятся сырые переходы, 0 означает без ограничения
+	RetentionDays int
+	// AdminToken открывает удаление данных любой ссылки, пустой токен отключает эту возможность
+	AdminToken string
 }
 
 type GetURL struct {
-	URL string `json:"url"`
+	URL          string `json:"url"`
+	Interstitial bool   `json:"interstitial"`
 }
 
 func generateShortURL(longURL string) string {
@@ -51,10 +63,27 @@ func (h *Handler) URLShortening(c *ginext.Context) {
 
 	zlog.Logger.Info().Msgf("Link UUID: %s", linkUUID)
 
+	ownerToken, ownerTokenHash, err := repository.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
 	url := &repository.URL{
-		UUID:     &linkUUID,
-		ShortURL: shortURL,
-		URL:      longURL.URL,
+		UUID:           &linkUUID,
+		ShortURL:       shortURL,
+		URL:            longURL.URL,
+		Interstitial:   longURL.Interstitial,
+		OwnerTokenHash: ownerTokenHash,
+	}
+
+	// Ошибка получения метаданных не мешает созданию ссылки, превью просто будет без заголовка
+	metadata, err := h.Preview.Fetch(c.Request.Context(), longURL.URL)
+	if err != nil {
+		zlog.Logger.Warn().Msgf("Could not fetch preview for %s: %v", longURL.URL, err)
+	} else {
+		url.Title = metadata.Title
+		url.Description = metadata.Description
 	}
 
 	err = repository.InsertURL(h.DB, url)
@@ -65,26 +94,65 @@ func (h *Handler) URLShortening(c *ginext.Context) {
 
 	zlog.Logger.Info().Msg("URL shortened")
 
-	c.JSON(http.StatusOK, ginext.H{"shortURL": shortURL})
+	// Токен показывается только здесь, в базе хранится его хеш
+	c.JSON(http.StatusOK, ginext.H{"shortURL": shortURL, "ownerToken": ownerToken})
 }
 
 func (h *Handler) GoShortURL(c *ginext.Context) {
 	shortURL := c.Param("short_url")
 
+	// Суффикс "+" показывает превью вместо перехода
+	previewOnly := strings.HasSuffix(shortURL, "+")
+	shortURL = strings.TrimSuffix(shortURL, "+")
+
 	zlog.Logger.Info().Msgf("Go Short URL: %s", shortURL)
 
-	linkID, url, err := repository.GetLongURL(h.DB, shortURL)
+	link, err := repository.GetLink(h.DB, shortURL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
+	if link == nil {
+		c.JSON(http.StatusNotFound, ginext.H{"error": "link not found"})
+		return
+	}
+
+	if previewOnly || (link.Interstitial && c.Query("confirm") == "") {
+		renderPreview(c, link)
+		return
+	}
+
+	linkID := *link.UUID
+	url := link.URL
+
 	zlog.Logger.Info().Msg(linkID.String())
 
 	ip := c.ClientIP()
 
 	userAgent := c.GetHeader("User-Agent")
 
+	isBot := visitor.IsBot(c.Request.Method, userAgent)
+
+	var visitorID string
+
+	// При DNT/Sec-GPC переход учитывается без IP и идентификатора посетителя
+	if privacy.DoNotTrack(c.Request) {
+		ip = ""
+	} else {
+		visitorID, err = h.identifyVisitor(c, ip, userAgent, isBot)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+
+		ip, err = h.Anonymizer.Anonymize(ip)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
 	infoUUID := uuid.New()
 
 	info := &repository.URLInfo{
@@ -93,6 +161,8 @@ func (h *Handler) GoShortURL(c *ginext.Context) {
 		Time:      time.Now(),
 		UserAgent: userAgent,
 		IP:        ip,
+		VisitorID: visitorID,
+		IsBot:     isBot,
 	}
 
 	err = repository.SaveInfo(h.DB, info)
@@ -106,18 +176,62 @@ func (h *Handler) GoShortURL(c *ginext.Context) {
 	c.Redirect(http.StatusFound, url)
 }
 
+// identifyVisitor берет идентификатор посетителя из cookie, а при ее отсутствии — отпечаток IP и User-Agent.
+// Отпечаток первого визита сохраняется в подписанной cookie, чтобы повторные визиты за день считались
+// тем же посетителем. Cookie живет до смены соли, поэтому визиты за разные дни не связываются.
+func (h *Handler) identifyVisitor(c *ginext.Context, ip, userAgent string, isBot bool) (string, error) {
+	salt, err := h.Salts.Current()
+	if err != nil {
+		return "", err
+	}
+
+	cookie, err := c.Cookie(visitor.CookieName)
+	if err == nil {
+		if visitorID, ok := visitor.VerifyID(salt, cookie); ok {
+			return visitorID, nil
+		}
+	}
+
+	visitorID := visitor.Fingerprint(salt, ip, userAgent)
+
+	if !isBot {
+		now := time.Now()
+		maxAge := int(visitor.NextRotation(now).Sub(now).Seconds())
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(visitor.CookieName, visitor.SignID(salt, visitorID), max(maxAge, 1), "/s/", "", false, true)
+	}
+
+	return visitorID, nil
+}
+
 func (h *Handler) Analytics(c *ginext.Context) {
 	shortURL := c.Param("short_url")
 
 	groupBy := c.Param("group")
 
-	var param string
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	var param, rollupParam string
+	var rollup repository.Rollup
 
 	switch groupBy {
+	case "hour":
+		param = "DATE_TRUNC('hour', time)"
+		rollupParam = "bucket"
+		rollup = repository.HourlyRollup
 	case "day":
 		param = "DATE(time)"
+		rollupParam = "DATE(bucket)"
+		rollup = repository.DailyRollup
 	case "month":
 		param = "DATE_TRUNC('month', time)"
+		rollupParam = "DATE_TRUNC('month', bucket)"
+		rollup = repository.DailyRollup
 	case "user_agent":
 		param = "user_agent"
 	default:
@@ -133,7 +247,11 @@ func (h *Handler) Analytics(c *ginext.Context) {
 	if param != "" {
 		urlGroupsInfo := make([]repository.AnalyticsGroups, 0)
 
-		urlGroupsInfo, err = repository.GetInfoWithGroup(h.DB, shortURL, param)
+		if rollupParam != "" && h.useRollups(from, to) {
+			urlGroupsInfo, err = repository.GetRollupInfoWithGroup(h.DB, rollup, linkID, rollupParam, from, to)
+		} else {
+			urlGroupsInfo, err = repository.GetInfoWithGroup(h.DB, shortURL, param, from, to)
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 			return
@@ -149,7 +267,7 @@ func (h *Handler) Analytics(c *ginext.Context) {
 	} else {
 		urlInfo := make([]repository.URLInfo, 0)
 
-		urlInfo, err = repository.GetInfo(h.DB, shortURL)
+		urlInfo, err = repository.GetInfo(h.DB, shortURL, from, to)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 			return
@@ -164,3 +282,32 @@ func (h *Handler) Analytics(c *ginext.Context) {
 		})
 	}
 }
+
+func (h *Handler) EraseClicks(c *ginext.Context) {
+	shortURL := c.Param("short_url")
+
+	linkID, _, err := repository.GetLongURL(h.DB, shortURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if linkID == uuid.Nil {
+		c.JSON(http.StatusNotFound, ginext.H{"error": "link not found"})
+		return
+	}
+
+	if !h.authorizeOwner(c, linkID) {
+		return
+	}
+
+	err = repository.EraseClicks(h.DB, linkID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	zlog.Logger.Info().Msg("Click data erased")
+
+	c.JSON(http.StatusOK, ginext.H{"result": "click data erased"})
+}
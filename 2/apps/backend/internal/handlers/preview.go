@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="robots" content="noindex">
+    <title>{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</title>
+</head>
+<body>
+    <h1>Вы переходите по ссылке</h1>
+    {{if .Title}}<h2>{{.Title}}</h2>{{end}}
+    {{if .Description}}<p>{{.Description}}</p>{{end}}
+    <p>Адрес назначения: <code>{{.URL}}</code></p>
+    <p><a href="/s/{{.ShortURL}}?confirm=1" rel="nofollow">Перейти</a></p>
+</body>
+</html>
+`))
+
+// renderPreview показывает страницу с адресом назначения и его Open Graph метаданными
+func renderPreview(c *ginext.Context, link *repository.URL) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	err := previewTemplate.Execute(c.Writer, link)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error rendering preview: %v", err)
+		return
+	}
+
+	zlog.Logger.Info().Msg("Preview rendered")
+}
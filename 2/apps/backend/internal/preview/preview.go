@@ -0,0 +1,216 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultMaxBytes = 1 << 20
+
+	maxTitleLength       = 255
+	maxDescriptionLength = 1000
+)
+
+var ErrPrivateAddress = errors.New("destination resolves to a private address")
+
+// sharedAddressSpace — адреса операторского NAT (RFC 6598), за которыми тоже бывают внутренние сервисы
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+type Metadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Fetcher загружает страницу назначения и достает из нее Open Graph теги.
+// Client можно подменить, а AllowPrivate разрешает локальные адреса, например для httptest-сервера.
+type Fetcher struct {
+	Client       *http.Client
+	MaxBytes     int64
+	AllowPrivate bool
+}
+
+func NewFetcher() *Fetcher {
+	f := &Fetcher{
+		MaxBytes: defaultMaxBytes,
+	}
+
+	dialer := &net.Dialer{
+		Timeout: defaultTimeout,
+		Control: f.checkAddress,
+	}
+
+	f.Client = &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   defaultTimeout,
+			ResponseHeaderTimeout: defaultTimeout,
+		},
+	}
+
+	return f
+}
+
+// checkAddress запрещает соединения с внутренними адресами, чтобы сокращатель нельзя было использовать для SSRF
+func (f *Fetcher) checkAddress(_, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !isPublic(net.ParseIP(host)) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+func isPublic(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !sharedAddressSpace.Contains(ip)
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Metadata, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "L3-Shortener-Preview/1.0")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/html" {
+		return nil, fmt.Errorf("unexpected content type %q", contentType)
+	}
+
+	return Parse(io.LimitReader(resp.Body, f.MaxBytes))
+}
+
+// Parse читает og:title и og:description, а при их отсутствии — <title> и meta description
+func Parse(r io.Reader) (*Metadata, error) {
+	tokenizer := html.NewTokenizer(r)
+
+	var ogTitle, ogDescription, title, description string
+	inTitle := false
+
+	// Метаданные находятся в <head>, поэтому чтение заканчивается на <body>
+	for done := false; !done; {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "meta":
+				key, content := metaAttributes(token)
+
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				}
+			case "body":
+				done = true
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "title" {
+				inTitle = false
+			}
+		}
+	}
+
+	return &Metadata{
+		Title:       truncate(firstNonEmpty(ogTitle, title), maxTitleLength),
+		Description: truncate(firstNonEmpty(ogDescription, description), maxDescriptionLength),
+	}, nil
+}
+
+func metaAttributes(token html.Token) (string, string) {
+	var key, content string
+
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "property", "name":
+			key = strings.ToLower(attr.Val)
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+
+	return key, content
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+
+	return string(runes[:limit])
+}
@@ -0,0 +1,150 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestFetcher() *Fetcher {
+	f := NewFetcher()
+	f.AllowPrivate = true
+
+	return f
+}
+
+func serve(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetchParsesOpenGraph(t *testing.T) {
+	server := serve(t, "Text/HTML; charset=UTF-8", `<!doctype html>
+<html><head>
+<title>Fallback title</title>
+<meta name="description" content="Fallback description">
+<meta property="og:title" content=" Open Graph title ">
+<meta property="OG:Description" content="Open Graph description">
+</head><body><meta property="og:title" content="Ignored"></body></html>`)
+
+	meta, err := newTestFetcher().Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if meta.Title != "Open Graph title" {
+		t.Errorf("Title = %q, want %q", meta.Title, "Open Graph title")
+	}
+
+	if meta.Description != "Open Graph description" {
+		t.Errorf("Description = %q, want %q", meta.Description, "Open Graph description")
+	}
+}
+
+func TestFetchFallsBackToTitle(t *testing.T) {
+	server := serve(t, "text/html", `<html><head><title> Plain title </title>
+<meta name="description" content="Plain description"></head></html>`)
+
+	meta, err := newTestFetcher().Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if meta.Title != "Plain title" || meta.Description != "Plain description" {
+		t.Errorf("meta = %+v, want the <title> and meta description", meta)
+	}
+}
+
+func TestFetchStopsAtMaxBytes(t *testing.T) {
+	// Теги после ограничения не должны быть прочитаны
+	head := `<html><head><meta property="og:title" content="Early">`
+	padding := `<meta name="padding" content="` + strings.Repeat("x", 4096) + `">`
+	server := serve(t, "text/html", head+padding+`<meta property="og:description" content="Late"></head></html>`)
+
+	f := newTestFetcher()
+	f.MaxBytes = int64(len(head) + 1024)
+
+	meta, err := f.Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if meta.Title != "Early" {
+		t.Errorf("Title = %q, want %q", meta.Title, "Early")
+	}
+
+	if meta.Description != "" {
+		t.Errorf("Description = %q, want it cut off by MaxBytes", meta.Description)
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	for _, contentType := range []string{"application/json", "text/plain; charset=utf-8", "text/htmlx", ""} {
+		server := serve(t, contentType, `<html><head><title>Not a page</title></head></html>`)
+
+		if _, err := newTestFetcher().Fetch(context.Background(), server.URL); err == nil {
+			t.Errorf("Fetch with Content-Type %q: want an error", contentType)
+		}
+	}
+}
+
+func TestFetchRejectsPrivateAddress(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher().Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Fetch %s: err = %v, want %v", server.URL, err, ErrPrivateAddress)
+	}
+
+	if requests.Load() != 0 {
+		t.Fatalf("server got %d requests, want none", requests.Load())
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		private bool
+	}{
+		{"127.0.0.1:80", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:443", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"100.64.0.1:80", true},
+		{"100.127.255.254:80", true},
+		{"0.0.0.0:80", true},
+		{"[::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"[fe80::1]:80", true},
+		{"100.128.0.1:80", false},
+		{"93.184.216.34:443", false},
+		{"[2606:4700::1111]:443", false},
+	}
+
+	f := NewFetcher()
+
+	for _, tt := range tests {
+		err := f.checkAddress("tcp", tt.address, nil)
+		if got := errors.Is(err, ErrPrivateAddress); got != tt.private {
+			t.Errorf("checkAddress(%s) = %v, want private %v", tt.address, err, tt.private)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package privacy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/Kost0/L3/internal/visitor"
+)
+
+const (
+	ModeFull     = "full"
+	ModeTruncate = "truncate"
+	ModeHash     = "hash"
+)
+
+// Anonymizer приводит IP клиента к виду, в котором он сохраняется в link_following
+type Anonymizer struct {
+	Mode  string
+	Salts *visitor.SaltStore
+}
+
+func NewAnonymizer(mode string, salts *visitor.SaltStore) (*Anonymizer, error) {
+	if mode == "" {
+		mode = ModeTruncate
+	}
+
+	switch mode {
+	case ModeFull, ModeTruncate, ModeHash:
+	default:
+		return nil, fmt.Errorf("unknown IP mode %q", mode)
+	}
+
+	return &Anonymizer{
+		Mode:  mode,
+		Salts: salts,
+	}, nil
+}
+
+func (a *Anonymizer) Anonymize(ip string) (string, error) {
+	switch a.Mode {
+	case ModeTruncate:
+		return truncateIP(ip), nil
+	case ModeHash:
+		salt, err := a.Salts.Current()
+		if err != nil {
+			return "", err
+		}
+
+		hash := sha256.Sum256([]byte(salt + "|" + ip))
+
+		return hex.EncodeToString(hash[:16]), nil
+	default:
+		return ip, nil
+	}
+}
+
+// truncateIP обнуляет адрес узла: для IPv4 остается сеть /24, для IPv6 — /48
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// DoNotTrack сообщает, что клиент отказался от отслеживания заголовком DNT или Sec-GPC
+func DoNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
@@ -7,9 +7,14 @@ import (
 )
 
 type URL struct {
-	UUID     *uuid.UUID `json:"uuid"`
-	URL      string     `json:"url"`
-	ShortURL string     `json:"short_url"`
+	UUID         *uuid.UUID `json:"uuid"`
+	URL          string     `json:"url"`
+	ShortURL     string     `json:"short_url"`
+	Interstitial bool       `json:"interstitial"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	// OwnerTokenHash — хеш токена, который выдается создателю ссылки для управления ее данными
+	OwnerTokenHash string `json:"-"`
 }
 
 type URLInfo struct {
@@ -18,10 +23,24 @@ type URLInfo struct {
 	Time      time.Time  `json:"time"`
 	UserAgent string     `json:"user_agent"`
 	IP        string     `json:"ip"`
+	VisitorID string     `json:"visitor_id"`
+	IsBot     bool       `json:"is_bot"`
 }
 
 type AnalyticsGroups struct {
 	Parameter      string `json:"parameter"`
 	Visitors       int    `json:"visitors"`
+	HumanVisits    int    `json:"human_visits"`
+	BotVisits      int    `json:"bot_visits"`
 	UniqueVisitors int    `json:"unique_visitors"`
 }
+
+type Rollup struct {
+	Table string
+	Unit  string
+}
+
+type ClickCursor struct {
+	Time time.Time
+	ID   uuid.UUID
+}
@@ -52,10 +52,40 @@ func GetLongURL(db *dbpg.DB, shortURL string) (uuid.UUID, string, error) {
 	return linkUUID, url, nil
 }
 
+// GetLink возвращает ссылку вместе с метаданными превью, nil если ссылки нет
+func GetLink(db *dbpg.DB, shortURL string) (*URL, error) {
+	query := `SELECT id, short_url, url, interstitial, COALESCE(title, ''), COALESCE(description, '') FROM link WHERE short_url = $1`
+
+	row, err := db.QueryWithRetry(context.Background(), retryStrategy, query, shortURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = row.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	if !row.Next() {
+		return nil, nil
+	}
+
+	link := &URL{}
+
+	err = row.Scan(&link.UUID, &link.ShortURL, &link.URL, &link.Interstitial, &link.Title, &link.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
 func InsertURL(db *dbpg.DB, url *URL) error {
-	query := `INSERT INTO link VALUES ($1, $2, $3)`
+	query := `INSERT INTO link (id, short_url, url, interstitial, title, description, owner_token_hash) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`
 
-	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, url.UUID, url.ShortURL, url.URL)
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, url.UUID, url.ShortURL, url.URL, url.Interstitial, url.Title, url.Description, url.OwnerTokenHash)
 	if err != nil {
 		return err
 	}
@@ -66,9 +96,9 @@ func InsertURL(db *dbpg.DB, url *URL) error {
 }
 
 func SaveInfo(db *dbpg.DB, url *URLInfo) error {
-	query := `INSERT INTO link_following VALUES ($1, $2, $3, $4, $5)`
+	query := `INSERT INTO link_following (id, link_id, time, user_agent, ip, visitor_id, is_bot) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)`
 
-	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, url.UUID, url.LinkID, url.Time, url.UserAgent, url.IP)
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, url.UUID, url.LinkID, url.Time, url.UserAgent, url.IP, url.VisitorID, url.IsBot)
 	if err != nil {
 		return err
 	}
@@ -78,12 +108,12 @@ func SaveInfo(db *dbpg.DB, url *URLInfo) error {
 	return nil
 }
 
-func GetInfo(db *dbpg.DB, shortURL string) ([]URLInfo, error) {
-	query := `SELECT l.id, l.link_id, l.time, l.user_agent, l.ip FROM link_following l
+func GetInfo(db *dbpg.DB, shortURL string, from, to time.Time) ([]URLInfo, error) {
+	query := `SELECT l.id, l.link_id, l.time, l.user_agent, l.ip, COALESCE(l.visitor_id, ''), l.is_bot FROM link_following l
 JOIN link ON link.id = l.link_id
-WHERE link.short_url = $1`
+WHERE link.short_url = $1 AND l.time >= $2 AND l.time < $3`
 
-	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, shortURL)
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, shortURL, from, to)
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +137,8 @@ WHERE link.short_url = $1`
 			&urlInfo.Time,
 			&urlInfo.UserAgent,
 			&urlInfo.IP,
+			&urlInfo.VisitorID,
+			&urlInfo.IsBot,
 		)
 		if err != nil {
 			return nil, err
@@ -118,15 +150,18 @@ WHERE link.short_url = $1`
 	return allURLInfo, nil
 }
 
-func GetInfoWithGroup(db *dbpg.DB, shortURL, param string) ([]AnalyticsGroups, error) {
-	query := fmt.Sprintf(`SELECT %s as parameter, COUNT(*) as visits, COUNT(DISTINCT ip) as unique_visitors FROM link_following l
+func GetInfoWithGroup(db *dbpg.DB, shortURL, param string, from, to time.Time) ([]AnalyticsGroups, error) {
+	query := fmt.Sprintf(`SELECT %s as parameter, COUNT(*) as visits,
+	COUNT(*) FILTER (WHERE NOT l.is_bot) as human_visits,
+	COUNT(*) FILTER (WHERE l.is_bot) as bot_visits,
+	COUNT(DISTINCT NULLIF(COALESCE(l.visitor_id, l.ip), '')) FILTER (WHERE NOT l.is_bot) as unique_visitors FROM link_following l
 	JOIN link ON link.id = l.link_id
-	WHERE link.short_url = $1
+	WHERE link.short_url = $1 AND l.time >= $2 AND l.time < $3
 	GROUP BY %s`, param, param)
 
-	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, shortURL)
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, shortURL, from, to)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
 	defer func() {
@@ -145,6 +180,8 @@ func GetInfoWithGroup(db *dbpg.DB, shortURL, param string) ([]AnalyticsGroups, e
 		err = rows.Scan(
 			&AnalyticsGroup.Parameter,
 			&AnalyticsGroup.Visitors,
+			&AnalyticsGroup.HumanVisits,
+			&AnalyticsGroup.BotVisits,
 			&AnalyticsGroup.UniqueVisitors,
 		)
 		if err != nil {
@@ -156,3 +193,29 @@ func GetInfoWithGroup(db *dbpg.DB, shortURL, param string) ([]AnalyticsGroups, e
 
 	return allURLInfo, nil
 }
+
+// GetOrCreateSalt сохраняет соль для дня, если ее еще нет, и удаляет соли прошлых дней
+func GetOrCreateSalt(db *dbpg.DB, day, candidate string) (string, error) {
+	query := `INSERT INTO visitor_salt (day, salt) VALUES ($1, $2) ON CONFLICT (day) DO NOTHING`
+
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, day, candidate)
+	if err != nil {
+		return "", err
+	}
+
+	var salt string
+
+	err = db.QueryRowContext(context.Background(), `SELECT salt FROM visitor_salt WHERE day = $1`, day).Scan(&salt)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecWithRetry(context.Background(), retryStrategy, `DELETE FROM visitor_salt WHERE day < $1`, day)
+	if err != nil {
+		return "", err
+	}
+
+	zlog.Logger.Info().Msgf("Visitor salt for %s loaded", day)
+
+	return salt, nil
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var (
+	HourlyRollup = Rollup{Table: "link_stats_hourly", Unit: "hour"}
+	DailyRollup  = Rollup{Table: "link_stats_daily", Unit: "day"}
+)
+
+// GetRollupWatermark возвращает начало последнего посчитанного интервала
+func GetRollupWatermark(db *dbpg.DB, rollup Rollup) (time.Time, error) {
+	query := fmt.Sprintf(`SELECT COALESCE(MAX(bucket), '1970-01-01'::timestamp) FROM %s`, rollup.Table)
+
+	var watermark time.Time
+
+	err := db.QueryRowContext(context.Background(), query).Scan(&watermark)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return watermark, nil
+}
+
+// RefreshRollup пересчитывает все интервалы, начиная с интервала, в который попадает since.
+// Последний интервал может быть неполным, поэтому он пересчитывается при каждом запуске.
+func RefreshRollup(db *dbpg.DB, rollup Rollup, since time.Time) error {
+	query := fmt.Sprintf(`INSERT INTO %s (link_id, bucket, visits, human_visits, bot_visits, unique_visitors)
+	SELECT link_id, DATE_TRUNC('%s', time) AS bucket, COUNT(*),
+	COUNT(*) FILTER (WHERE NOT is_bot),
+	COUNT(*) FILTER (WHERE is_bot),
+	COUNT(DISTINCT NULLIF(COALESCE(visitor_id, ip), '')) FILTER (WHERE NOT is_bot) FROM link_following
+	WHERE time >= DATE_TRUNC('%s', $1::timestamp)
+	GROUP BY link_id, bucket
+	ON CONFLICT (link_id, bucket) DO UPDATE
+	SET visits = EXCLUDED.visits, human_visits = EXCLUDED.human_visits,
+	bot_visits = EXCLUDED.bot_visits, unique_visitors = EXCLUDED.unique_visitors`, rollup.Table, rollup.Unit, rollup.Unit)
+
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, since)
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msgf("Rollup %s refreshed", rollup.Table)
+
+	return nil
+}
+
+// GetRollupInfoWithGroup считает статистику по готовым агрегатам.
+// Уникальные посетители суммируются по интервалам, поэтому для крупных групп это верхняя оценка.
+func GetRollupInfoWithGroup(db *dbpg.DB, rollup Rollup, linkID uuid.UUID, param string, from, to time.Time) ([]AnalyticsGroups, error) {
+	query := fmt.Sprintf(`SELECT %s as parameter, SUM(visits) as visits, SUM(human_visits) as human_visits,
+	SUM(bot_visits) as bot_visits, SUM(unique_visitors) as unique_visitors FROM %s
+	WHERE link_id = $1 AND bucket >= DATE_TRUNC('%s', $2::timestamp) AND bucket < $3
+	GROUP BY %s
+	ORDER BY %s`, param, rollup.Table, rollup.Unit, param, param)
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, linkID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	zlog.Logger.Info().Msgf("Getting url following information from %s", rollup.Table)
+
+	allURLInfo := make([]AnalyticsGroups, 0)
+
+	for rows.Next() {
+		analyticsGroup := &AnalyticsGroups{}
+		err = rows.Scan(
+			&analyticsGroup.Parameter,
+			&analyticsGroup.Visitors,
+			&analyticsGroup.HumanVisits,
+			&analyticsGroup.BotVisits,
+			&analyticsGroup.UniqueVisitors,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		allURLInfo = append(allURLInfo, *analyticsGroup)
+	}
+
+	return allURLInfo, nil
+}
+
+// StreamInfo отдает переходы по ссылке в порядке (time, id), начиная после курсора
+func StreamInfo(db *dbpg.DB, linkID uuid.UUID, after ClickCursor, to time.Time, limit int, fn func(*URLInfo) error) error {
+	query := `SELECT id, link_id, time, user_agent, ip, COALESCE(visitor_id, ''), is_bot FROM link_following
+	WHERE link_id = $1 AND (time, id) > ($2, $3) AND time < $4
+	ORDER BY time, id
+	LIMIT $5`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, linkID, after.Time, after.ID, to, limit)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	for rows.Next() {
+		urlInfo := &URLInfo{}
+		err = rows.Scan(
+			&urlInfo.UUID,
+			&urlInfo.LinkID,
+			&urlInfo.Time,
+			&urlInfo.UserAgent,
+			&urlInfo.IP,
+			&urlInfo.VisitorID,
+			&urlInfo.IsBot,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(urlInfo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// DeleteClicksBefore удаляет сырые переходы старше before
+func DeleteClicksBefore(db *dbpg.DB, before time.Time) (int64, error) {
+	query := `DELETE FROM link_following WHERE time < $1`
+
+	res, err := db.ExecWithRetry(context.Background(), retryStrategy, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// EraseClicks удаляет все переходы по ссылке вместе с агрегатами
+func EraseClicks(db *dbpg.DB, linkID uuid.UUID) error {
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, table := range []string{"link_following", HourlyRollup.Table, DailyRollup.Table} {
+		_, err = tx.ExecContext(context.Background(), fmt.Sprintf(`DELETE FROM %s WHERE link_id = $1`, table), linkID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msgf("Click data erased for link %s", linkID)
+
+	return nil
+}
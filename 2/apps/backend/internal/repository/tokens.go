@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// NewToken возвращает случайный токен для владельца ссылки и его хеш для хранения в базе
+func NewToken() (string, string, error) {
+	buf := make([]byte, 32)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(buf)
+
+	return token, HashToken(token), nil
+}
+
+// HashToken возвращает SHA-256 токена в hex
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// OwnerTokenMatches проверяет токен владельца ссылки. У ссылок, созданных до появления
+// токенов, хеша нет, и распоряжаться их данными может только администратор.
+func OwnerTokenMatches(db *dbpg.DB, linkID uuid.UUID, token string) (bool, error) {
+	query := `SELECT COALESCE(owner_token_hash, '') FROM link WHERE id = $1`
+
+	row, err := db.QueryRowWithRetry(context.Background(), retryStrategy, query, linkID)
+	if err != nil {
+		return false, err
+	}
+
+	var hash string
+
+	err = row.Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if token == "" || hash == "" {
+		return false, nil
+	}
+
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1, nil
+}
@@ -0,0 +1,57 @@
+package visitor
+
+import (
+	"net/http"
+	"strings"
+)
+
+// botPatterns — подстроки User-Agent краулеров, сервисов превью ссылок и health check'ов
+var botPatterns = []string{
+	"bot",
+	"crawler",
+	"spider",
+	"slurp",
+	"facebookexternalhit",
+	"facebookcatalog",
+	"whatsapp",
+	"telegram",
+	"skypeuripreview",
+	"embedly",
+	"vkshare",
+	"bitlybot",
+	"preview",
+	"headless",
+	"curl/",
+	"wget/",
+	"python-requests",
+	"go-http-client",
+	"okhttp",
+	"kube-probe",
+	"healthcheck",
+	"health-check",
+	"elb-healthchecker",
+	"uptimerobot",
+	"pingdom",
+	"statuscake",
+}
+
+// IsBot определяет, сделан ли переход не человеком.
+// HEAD-запросы отправляют только сервисы превью и мониторинг, браузер при переходе по ссылке использует GET.
+func IsBot(method, userAgent string) bool {
+	if method == http.MethodHead {
+		return true
+	}
+
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		return true
+	}
+
+	for _, pattern := range botPatterns {
+		if strings.Contains(ua, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package visitor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+const CookieName = "vid"
+
+// Fingerprint хеширует IP и User-Agent с солью текущего дня.
+// Соль меняется каждый день, поэтому по отпечатку нельзя восстановить IP или связать визиты за разные дни.
+func Fingerprint(salt, ip, userAgent string) string {
+	hash := sha256.Sum256([]byte(salt + "|" + ip + "|" + userAgent))
+	return hex.EncodeToString(hash[:])
+}
+
+// SaltStore выдает соль текущего дня, общую для всех реплик через таблицу visitor_salt
+type SaltStore struct {
+	DB   *dbpg.DB
+	day  string
+	salt string
+	mu   sync.Mutex
+}
+
+func NewSaltStore(db *dbpg.DB) *SaltStore {
+	return &SaltStore{
+		DB: db,
+	}
+}
+
+func (s *SaltStore) Current() (string, error) {
+	day := time.Now().UTC().Format(time.DateOnly)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.day == day {
+		return s.salt, nil
+	}
+
+	candidate := make([]byte, 32)
+
+	_, err := rand.Read(candidate)
+	if err != nil {
+		return "", err
+	}
+
+	salt, err := repository.GetOrCreateSalt(s.DB, day, hex.EncodeToString(candidate))
+	if err != nil {
+		return "", err
+	}
+
+	s.day = day
+	s.salt = salt
+
+	return salt, nil
+}
+
+// NextRotation возвращает момент смены соли — начало следующего дня по UTC
+func NextRotation(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// SignID возвращает значение cookie: отпечаток и его подпись солью текущего дня.
+// Подделать такое значение без соли нельзя, а после смены соли подпись перестает сходиться.
+func SignID(salt, id string) string {
+	return id + "." + signature(salt, id)
+}
+
+// VerifyID возвращает отпечаток из cookie, если он подписан солью salt
+func VerifyID(salt, value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || len(id) != sha256.Size*2 {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(salt, id))) {
+		return "", false
+	}
+
+	return id, true
+}
+
+func signature(salt, id string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(id))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
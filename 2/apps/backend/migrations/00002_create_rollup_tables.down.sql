@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS link_stats_daily;
+DROP TABLE IF EXISTS link_stats_hourly;
+DROP INDEX IF EXISTS link_following_link_id_time_idx;
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE INDEX link_following_link_id_time_idx ON link_following (link_id, time, id);
+
+CREATE TABLE link_stats_hourly (
+    link_id UUID REFERENCES link(id),
+    bucket TIMESTAMP,
+    visits INT,
+    unique_visitors INT,
+    PRIMARY KEY (link_id, bucket)
+);
+
+CREATE TABLE link_stats_daily (
+    link_id UUID REFERENCES link(id),
+    bucket TIMESTAMP,
+    visits INT,
+    unique_visitors INT,
+    PRIMARY KEY (link_id, bucket)
+);
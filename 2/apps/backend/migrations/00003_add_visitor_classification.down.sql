@@ -0,0 +1,7 @@
+ALTER TABLE link_stats_daily DROP COLUMN IF EXISTS bot_visits;
+ALTER TABLE link_stats_daily DROP COLUMN IF EXISTS human_visits;
+ALTER TABLE link_stats_hourly DROP COLUMN IF EXISTS bot_visits;
+ALTER TABLE link_stats_hourly DROP COLUMN IF EXISTS human_visits;
+DROP TABLE IF EXISTS visitor_salt;
+ALTER TABLE link_following DROP COLUMN IF EXISTS is_bot;
+ALTER TABLE link_following DROP COLUMN IF EXISTS visitor_id;
\ No newline at end of file
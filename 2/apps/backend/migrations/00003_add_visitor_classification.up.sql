@@ -0,0 +1,15 @@
+ALTER TABLE link_following ADD COLUMN visitor_id VARCHAR(64);
+ALTER TABLE link_following ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE visitor_salt (
+    day DATE PRIMARY KEY,
+    salt VARCHAR(64)
+);
+
+ALTER TABLE link_stats_hourly ADD COLUMN human_visits INT NOT NULL DEFAULT 0;
+ALTER TABLE link_stats_hourly ADD COLUMN bot_visits INT NOT NULL DEFAULT 0;
+ALTER TABLE link_stats_daily ADD COLUMN human_visits INT NOT NULL DEFAULT 0;
+ALTER TABLE link_stats_daily ADD COLUMN bot_visits INT NOT NULL DEFAULT 0;
+
+-- Агрегаты будут пересчитаны с учетом ботов
+TRUNCATE link_stats_hourly, link_stats_daily;
@@ -0,0 +1,3 @@
+ALTER TABLE link DROP COLUMN IF EXISTS description;
+ALTER TABLE link DROP COLUMN IF EXISTS title;
+ALTER TABLE link DROP COLUMN IF EXISTS interstitial;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE link ADD COLUMN interstitial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE link ADD COLUMN title VARCHAR(255);
+ALTER TABLE link ADD COLUMN description TEXT;
@@ -0,0 +1 @@
+ALTER TABLE link DROP COLUMN IF EXISTS owner_token_hash;
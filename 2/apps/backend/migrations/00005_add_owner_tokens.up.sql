@@ -0,0 +1 @@
+ALTER TABLE link ADD COLUMN owner_token_hash VARCHAR(64);
@@ -1,8 +1,19 @@
 package main
 
 import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Kost0/L3/internal/blob"
 	"github.com/Kost0/L3/internal/handlers"
+	"github.com/Kost0/L3/internal/live"
+	"github.com/Kost0/L3/internal/middleware"
+	"github.com/Kost0/L3/internal/moderation"
+	"github.com/Kost0/L3/internal/notify"
+	"github.com/Kost0/L3/internal/ratelimit"
 	"github.com/Kost0/L3/internal/repository"
+	"github.com/Kost0/L3/internal/unfurl"
 	"github.com/gin-contrib/cors"
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/zlog"
@@ -24,16 +35,68 @@ func main() {
 
 	zlog.Logger.Info().Msg("DB started")
 
+	// Локальные адреса подписок нужны только для проверки с cmd/webhook-receiver
+	allowPrivateWebhooks := os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+
+	// Фоновая отправка уведомлений об ответах и упоминаниях подписчикам
+	dispatcher := notify.NewDispatcher(db, 5*time.Second)
+	dispatcher.AllowPrivate = allowPrivateWebhooks
+
+	go dispatcher.Start()
+
+	// События обсуждений приходят от всех реплик через LISTEN/NOTIFY
+	hub := live.NewHub()
+
+	go live.NewListener(db, repository.ConnString(), hub).Start()
+
+	// Для нескольких реплик ограничения нужно хранить в Postgres
+	rateLimitStore, err := ratelimit.NewStore(os.Getenv("RATE_LIMIT_STORE"), db)
+	if err != nil {
+		panic(err)
+	}
+
+	limiter := ratelimit.NewLimiter(rateLimitStore, ratelimit.DefaultConfig())
+
+	go limiter.StartCleanup(time.Minute)
+
+	attachmentsDir := os.Getenv("ATTACHMENTS_DIR")
+	if attachmentsDir == "" {
+		attachmentsDir = "data"
+	}
+
+	blobs, err := blob.NewLocalStore(attachmentsDir)
+	if err != nil {
+		panic(err)
+	}
+
 	handler := handlers.Handler{
-		DB: db,
+		DB:        db,
+		Moderator: moderation.NewDefaultModerator(os.Getenv("PROFANITY_WORDS")),
+		Hub:       hub,
+		Limiter:   limiter,
+		Blobs:     blobs,
+		Unfurler:  unfurl.NewFetcher(),
+
+		AllowPrivateWebhooks: allowPrivateWebhooks,
 	}
 
 	engine := ginext.New("")
 
+	// По умолчанию gin доверяет X-Forwarded-For от любого клиента, и подменой заголовка можно
+	// обойти ограничения по IP. Заголовок учитывается только от прокси из TRUSTED_PROXIES.
+	err = engine.SetTrustedProxies(trustedProxies(os.Getenv("TRUSTED_PROXIES")))
+	if err != nil {
+		panic(err)
+	}
+
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"http://localhost:5000"},
-		AllowMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
-		AllowHeaders: []string{"Origin", "Accept", "Content-Type"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{
+			"Origin", "Accept", "Content-Type", "Authorization",
+			handlers.SubscriptionTokenHeader, handlers.EditTokenHeader, handlers.ReactionTokenHeader,
+		},
+		ExposeHeaders: []string{handlers.ReactionTokenHeader},
 	}))
 
 	engine.POST("/comments", handler.CreateComment)
@@ -42,12 +105,68 @@ func main() {
 
 	engine.GET("/comments/all", handler.GetPageComments)
 
+	engine.GET("/comments/:id/tree", handler.GetCommentTree)
+
+	engine.GET("/comments/:id/revisions", handler.GetRevisions)
+
+	engine.GET("/comments/:id/events", handler.StreamCommentEvents)
+
+	engine.PATCH("/comments/:id", handler.UpdateComment)
+
+	engine.POST("/comments/:id/reactions", handler.AddReaction)
+
+	engine.DELETE("/comments/:id/reactions/:type", handler.RemoveReaction)
+
 	engine.DELETE("/comments/:id", handler.DeleteComment)
 
 	engine.GET("/comments/search", handler.SearchComment)
 
+	engine.GET("/attachments/:id", handler.GetAttachment)
+
+	engine.GET("/resources/:id", handler.GetResource)
+
+	engine.GET("/resources/:id/comments", handler.GetResourceComments)
+
+	engine.GET("/resources/:id/events", handler.StreamResourceEvents)
+
+	engine.POST("/subscriptions", handler.CreateSubscription)
+
+	engine.GET("/subscriptions", handler.GetSubscriptions)
+
+	engine.DELETE("/subscriptions/:id", handler.DeleteSubscription)
+
+	engine.GET("/subscriptions/:id/deliveries", handler.GetDeliveries)
+
+	admin := engine.Group("/admin", middleware.AdminMiddleware())
+
+	admin.DELETE("/comments/:id", handler.PurgeComment)
+
+	admin.PUT("/resources/:id", handler.UpdateResource)
+
+	admin.GET("/moderation/pending", handler.GetPendingComments)
+
+	admin.POST("/moderation/:id/approve", handler.ApproveComment)
+
+	admin.POST("/moderation/:id/reject", handler.RejectComment)
+
+	admin.GET("/moderation/log", handler.GetModerationLog)
+
 	err = engine.Run(":8080")
 	if err != nil {
 		panic(err)
 	}
 }
+
+// trustedProxies разбирает список адресов и подсетей через запятую, пустой список означает,
+// что клиентским адресом считается адрес соединения
+func trustedProxies(value string) []string {
+	var proxies []string
+
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
@@ -0,0 +1,67 @@
+// Локальный приемник уведомлений для проверки подписок:
+//
+//	go run ./cmd/webhook-receiver -addr :9090 -secret s3cret -fail 2
+//
+// Сервер должен быть запущен с WEBHOOK_ALLOW_PRIVATE=true, иначе локальный адрес не принимается.
+// Печатает каждое событие, проверяет подпись, если задан -secret, и отвечает 500
+// на первые -fail запросов, чтобы можно было увидеть повторные попытки доставки.
+package main
+
+import (
+	"crypto/hmac"
+	"flag"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Kost0/L3/internal/notify"
+	"github.com/wb-go/wbf/zlog"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	secret := flag.String("secret", "", "subscription secret to verify signatures")
+	fail := flag.Int64("fail", 0, "number of requests to answer with 500 before accepting")
+	flag.Parse()
+
+	zlog.Init()
+
+	var received atomic.Int64
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		n := received.Add(1)
+
+		if *secret != "" {
+			expected := notify.Sign(*secret, body)
+			if !hmac.Equal([]byte(expected), []byte(r.Header.Get(notify.SignatureHeader))) {
+				zlog.Logger.Warn().Msgf("#%d invalid signature for delivery %s", n, r.Header.Get(notify.DeliveryHeader))
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if n <= *fail {
+			zlog.Logger.Info().Msgf("#%d failing delivery %s on purpose", n, r.Header.Get(notify.DeliveryHeader))
+			http.Error(w, "try again later", http.StatusInternalServerError)
+			return
+		}
+
+		zlog.Logger.Info().Msgf("#%d %s delivery %s: %s", n, r.Header.Get(notify.EventHeader),
+			r.Header.Get(notify.DeliveryHeader), body)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	zlog.Logger.Info().Msgf("Webhook receiver listening on %s", *addr)
+
+	err := http.ListenAndServe(*addr, nil)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/Kost0/L3/internal/blob"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/Kost0/L3/internal/unfurl"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	maxAttachments    = 4
+	maxAttachmentSize = 5 << 20
+	maxUploadSize     = maxAttachments*maxAttachmentSize + 1<<20
+	maxFileNameLength = 255
+
+	unfurlTimeout = 10 * time.Second
+)
+
+// Разрешены только изображения, расширение файла в хранилище берется по реальному типу
+var attachmentTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var errInvalidAttachment = errors.New("invalid attachment")
+
+// parseMultipartComment читает поля комментария и файлы из поля attachments формы multipart/form-data
+func parseMultipartComment(c *ginext.Context, getComment *GetComment) ([]*multipart.FileHeader, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize)
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+
+	getComment.Text = c.PostForm("text")
+	getComment.Author = c.PostForm("author")
+	getComment.ResourceID = c.PostForm("resource_id")
+	getComment.Lang = c.PostForm("lang")
+
+	if parent := c.PostForm("parent"); parent != "" {
+		parentUUID, err := uuid.Parse(parent)
+		if err != nil {
+			return nil, errors.New("invalid parent")
+		}
+
+		getComment.Parent = &parentUUID
+	}
+
+	return form.File["attachments"], nil
+}
+
+// inspectAttachments проверяет размер и тип файлов по содержимому и возвращает описания вложений.
+// Файлы еще не сохранены, ключи в хранилище уже назначены.
+func inspectAttachments(files []*multipart.FileHeader) ([]*repository.Attachment, error) {
+	if len(files) > maxAttachments {
+		return nil, fmt.Errorf("%w: at most %d files are allowed", errInvalidAttachment, maxAttachments)
+	}
+
+	attachments := make([]*repository.Attachment, 0, len(files))
+
+	for _, file := range files {
+		if file.Size > maxAttachmentSize {
+			return nil, fmt.Errorf("%w: %s is larger than %d bytes", errInvalidAttachment, file.Filename, maxAttachmentSize)
+		}
+
+		attachment, err := inspectAttachment(file)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+func inspectAttachment(file *multipart.FileHeader) (*repository.Attachment, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	head := make([]byte, 512)
+
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// Заголовку Content-Type от клиента не доверяем, тип определяется по первым байтам
+	contentType := http.DetectContentType(head[:n])
+
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has unsupported type %s", errInvalidAttachment, file.Filename, contentType)
+	}
+
+	id := uuid.New()
+
+	attachment := &repository.Attachment{
+		UUID:        &id,
+		Key:         "attachments/" + id.String() + ext,
+		FileName:    truncateFileName(filepath.Base(file.Filename)),
+		ContentType: contentType,
+		Size:        file.Size,
+		CreatedAt:   time.Now(),
+	}
+
+	// Для WebP нет стандартного декодера, размеры остаются неизвестными
+	if contentType != "image/webp" {
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		config, _, err := image.DecodeConfig(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a valid image", errInvalidAttachment, file.Filename)
+		}
+
+		attachment.Width = &config.Width
+		attachment.Height = &config.Height
+	}
+
+	return attachment, nil
+}
+
+func truncateFileName(name string) string {
+	runes := []rune(name)
+	if len(runes) <= maxFileNameLength {
+		return name
+	}
+
+	return string(runes[:maxFileNameLength])
+}
+
+// storeAttachments сохраняет файлы в хранилище. При ошибке уже записанные файлы удаляются.
+func (h *Handler) storeAttachments(files []*multipart.FileHeader, attachments []*repository.Attachment) error {
+	for i, file := range files {
+		err := h.storeAttachment(file, attachments[i].Key)
+		if err != nil {
+			h.deleteBlobs(attachmentKeys(attachments[:i]))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) storeAttachment(file *multipart.FileHeader, key string) error {
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return h.Blobs.Put(key, f)
+}
+
+func attachmentKeys(attachments []*repository.Attachment) []string {
+	keys := make([]string, 0, len(attachments))
+
+	for _, attachment := range attachments {
+		keys = append(keys, attachment.Key)
+	}
+
+	return keys
+}
+
+// deleteBlobs удаляет файлы, записи о которых уже удалены или не были созданы.
+// Ошибка только логируется: лишний файл в хранилище не мешает работе.
+func (h *Handler) deleteBlobs(keys []string) {
+	for _, key := range keys {
+		err := h.Blobs.Delete(key)
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error deleting blob %s: %v", key, err)
+		}
+	}
+}
+
+// commentBlobKeys возвращает файлы комментария до его удаления, subtree — вместе с ответами
+func (h *Handler) commentBlobKeys(id uuid.UUID, subtree bool) []string {
+	keys, err := repository.SelectAttachmentKeys(h.DB, id, subtree)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error selecting attachments of %s: %v", id, err)
+		return nil
+	}
+
+	return keys
+}
+
+func (h *Handler) GetAttachment(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid attachment id"})
+		return
+	}
+
+	attachment, err := repository.SelectAttachment(h.DB, id)
+	if errors.Is(err, repository.ErrAttachmentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	reader, err := h.Blobs.Open(attachment.Key)
+	if errors.Is(err, blob.ErrNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	// Файл по ключу не меняется, поэтому его можно кешировать навсегда
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, reader, map[string]string{
+		"Cache-Control":          "public, max-age=31536000, immutable",
+		"Content-Disposition":    mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// unfurlPreview обновляет карточку первой ссылки комментария в фоне, чтобы запрос
+// не ждал сторонний сайт. current — карточка, сохраненная сейчас, если она есть.
+func (h *Handler) unfurlPreview(id uuid.UUID, text string, current *repository.LinkPreview) {
+	link := unfurl.FirstURL(text)
+
+	if current != nil && current.URL == link {
+		return
+	}
+
+	if link == "" {
+		if current != nil {
+			h.setPreview(id, nil)
+		}
+
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+		defer cancel()
+
+		preview, err := h.Unfurler.Unfurl(ctx, link)
+		if err != nil {
+			zlog.Logger.Warn().Msgf("Error unfurling %s: %v", link, err)
+		}
+
+		// Старая карточка относится к другой ссылке, поэтому без новой ее тоже нужно убрать
+		if preview == nil && current == nil {
+			return
+		}
+
+		h.setPreview(id, preview)
+	}()
+}
+
+func (h *Handler) setPreview(id uuid.UUID, preview *repository.LinkPreview) {
+	err := repository.SetCommentPreview(h.DB, id, preview)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error saving preview of %s: %v", id, err)
+	}
+}
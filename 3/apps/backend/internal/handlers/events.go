@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Kost0/L3/internal/live"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// Комментарий-пинг не дает прокси закрыть неактивное соединение
+const heartbeatInterval = 25 * time.Second
+
+// StreamCommentEvents отдает по SSE события ветки, в которую входит комментарий :id
+func (h *Handler) StreamCommentEvents(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	thread, err := repository.SelectCommentThread(h.DB, id)
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	h.streamEvents(c, live.RootTopic(*thread.RootID))
+}
+
+// StreamResourceEvents отдает по SSE события всего обсуждения ресурса
+func (h *Handler) StreamResourceEvents(c *ginext.Context) {
+	id := c.Param("id")
+
+	if len(id) > maxResourceLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "resource id is too long"})
+		return
+	}
+
+	h.streamEvents(c, live.ResourceTopic(id))
+}
+
+func (h *Handler) streamEvents(c *ginext.Context, topic string) {
+	events, unsubscribe := h.Hub.Subscribe(topic)
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+
+			c.SSEvent(event.Type, event)
+
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+
+			return err == nil
+		}
+	})
+}
+
+// commentEvent собирает событие по комментарию до изменения, которое может его удалить.
+// Ошибка не мешает основному запросу и только логируется.
+func (h *Handler) commentEvent(eventType string, id uuid.UUID) *repository.CommentEvent {
+	event, err := repository.SelectCommentThread(h.DB, id)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error building %s event for %s: %v", eventType, id, err)
+		return nil
+	}
+
+	event.Type = eventType
+
+	return event
+}
+
+func (h *Handler) publishEvent(event *repository.CommentEvent) {
+	if event == nil {
+		return
+	}
+
+	err := repository.PublishCommentEvent(h.DB, event)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error publishing %s event for %s: %v", event.Type, event.CommentID, err)
+	}
+}
@@ -2,55 +2,250 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Kost0/L3/internal/blob"
+	"github.com/Kost0/L3/internal/language"
+	"github.com/Kost0/L3/internal/live"
+	"github.com/Kost0/L3/internal/markdown"
+	"github.com/Kost0/L3/internal/middleware"
+	"github.com/Kost0/L3/internal/moderation"
+	"github.com/Kost0/L3/internal/notify"
+	"github.com/Kost0/L3/internal/ratelimit"
 	"github.com/Kost0/L3/internal/repository"
+	"github.com/Kost0/L3/internal/unfurl"
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/zlog"
 )
 
+const (
+	defaultTreeDepth = 5
+	maxTreeDepth     = 20
+	defaultTreeLimit = 20
+	maxTreeLimit     = 100
+	defaultPageLimit = 10
+	maxPageLimit     = 100
+)
+
 type Handler struct {
-	DB *dbpg.DB
+	DB        *dbpg.DB
+	Moderator *moderation.Moderator
+	Hub       *live.Hub
+	Limiter   *ratelimit.Limiter
+	Blobs     blob.Store
+	Unfurler  *unfurl.Fetcher
+	// AllowPrivateWebhooks разрешает подписки на локальные адреса, только для разработки
+	AllowPrivateWebhooks bool
 }
 
+const (
+	maxAuthorLength   = 100
+	maxResourceLength = 100
+	defaultResource   = "default"
+)
+
 type GetComment struct {
-	Text   string     `json:"text"`
-	Parent *uuid.UUID `json:"parent"`
+	Text       string     `json:"text"`
+	Parent     *uuid.UUID `json:"parent"`
+	Author     string     `json:"author"`
+	ResourceID string     `json:"resource_id"`
+	Lang       string     `json:"lang"`
 }
 
+// EditTokenHeader — заголовок с токеном правки для запросов без тела
+const EditTokenHeader = "X-Edit-Token"
+
+type EditComment struct {
+	Text      string `json:"text"`
+	Author    string `json:"author"`
+	EditToken string `json:"edit_token"`
+	Lang      string `json:"lang"`
+}
+
+// CreateComment принимает JSON или, если нужны вложения, multipart/form-data с теми же полями
+// и файлами в поле attachments
 func (h *Handler) CreateComment(c *ginext.Context) {
-	data, err := c.GetRawData()
+	getComment := &GetComment{}
+
+	var files []*multipart.FileHeader
+	var err error
+
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		files, err = parseMultipartComment(c, getComment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+	} else {
+		data, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+
+		err = json.Unmarshal(data, getComment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if getComment.Author == "" {
+		getComment.Author = "anonymous"
+	}
+
+	if len(getComment.Author) > maxAuthorLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "author is too long"})
+		return
+	}
+
+	if getComment.ResourceID == "" {
+		getComment.ResourceID = defaultResource
+	}
+
+	if len(getComment.ResourceID) > maxResourceLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "resource_id is too long"})
+		return
+	}
+
+	attachments, err := inspectAttachments(files)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
 
-	getComment := &GetComment{}
+	lang := language.Detect(getComment.Text)
+	if getComment.Lang != "" {
+		var ok bool
+
+		lang, ok = language.Config(getComment.Lang)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("unsupported lang %q", getComment.Lang)})
+			return
+		}
+	}
 
-	err = json.Unmarshal(data, getComment)
+	verdict := h.Moderator.Evaluate(getComment.Text)
+
+	html, err := markdown.Render(getComment.Text)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
 
+	editToken, editTokenHash, err := repository.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	// Ограничения проверяются после всех проверок запроса, а занятые ключи освобождаются,
+	// если комментарий не сохранился, чтобы исправленный запрос не считался повтором
+	decision, err := h.Limiter.Check(ratelimit.Request{
+		IP:     c.ClientIP(),
+		Author: getComment.Author,
+		Parent: getComment.Parent,
+		Text:   getComment.Text,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if !decision.Allowed {
+		retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, ginext.H{"error": decision.Reason, "retry_after": retryAfter})
+		return
+	}
+
+	err = h.storeAttachments(files, attachments)
+	if err != nil {
+		h.Limiter.Release(decision)
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
 	commentUUID := uuid.New()
 
 	comment := &repository.Comment{
-		UUID:   &commentUUID,
-		Text:   getComment.Text,
-		Parent: getComment.Parent,
+		UUID:        &commentUUID,
+		Text:        getComment.Text,
+		HTML:        html,
+		Parent:      getComment.Parent,
+		Author:      getComment.Author,
+		CreatedAt:   time.Now(),
+		ResourceID:  getComment.ResourceID,
+		Language:    lang,
+		Status:      verdict.Status,
+		Attachments: attachments,
+		// Токен отдается только в ответе на создание, в базе остается его хеш
+		EditTokenHash: editTokenHash,
 	}
 
-	err = repository.InsertComment(h.DB, comment)
+	err = repository.InsertComment(h.DB, comment, notify.ParseMentions(getComment.Text), autoModerationAction(commentUUID, verdict))
+	if err != nil {
+		h.deleteBlobs(attachmentKeys(attachments))
+		h.Limiter.Release(decision)
+	}
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": "parent comment not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrThreadLocked) {
+		c.JSON(http.StatusForbidden, ginext.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, ginext.H{"Comment": comment.UUID})
+	if verdict.Status == moderation.StatusPublished {
+		h.publishEvent(h.commentEvent(repository.CommentCreated, commentUUID))
+	}
+
+	// Ссылки из отклоненных комментариев не загружаются
+	if verdict.Status != moderation.StatusRejected {
+		h.unfurlPreview(commentUUID, getComment.Text, nil)
+	}
+
+	if verdict.Status == moderation.StatusRejected {
+		c.JSON(http.StatusUnprocessableEntity, ginext.H{"Comment": comment.UUID, "status": verdict.Status, "reasons": verdict.Reasons})
+		return
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"Comment": comment.UUID, "status": verdict.Status, "edit_token": editToken})
+}
+
+// autoModerationAction возвращает запись журнала о решении фильтров или nil, если комментарий
+// опубликован без замечаний. Запись сохраняется в одной транзакции с комментарием.
+func autoModerationAction(commentID uuid.UUID, verdict moderation.Verdict) *repository.ModerationAction {
+	if verdict.Status == moderation.StatusPublished {
+		return nil
+	}
+
+	actionUUID := uuid.New()
+
+	return &repository.ModerationAction{
+		UUID:      &actionUUID,
+		CommentID: &commentID,
+		Action:    verdict.Status,
+		Moderator: "auto",
+		Reason:    verdict.Reason(),
+		Score:     verdict.Score,
+		CreatedAt: time.Now(),
+	}
 }
 
 func (h *Handler) GetComments(c *ginext.Context) {
@@ -62,54 +257,361 @@ func (h *Handler) GetComments(c *ginext.Context) {
 		return
 	}
 
+	err = repository.LoadAttachments(h.DB, comments)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, comments)
 }
 
 func (h *Handler) GetPageComments(c *ginext.Context) {
+	h.respondPage(c, c.Query("resource_id"))
+}
+
+// respondPage отдает страницу корневых комментариев обсуждения, пустой resourceID означает все обсуждения
+func (h *Handler) respondPage(c *ginext.Context, resourceID string) {
 	zlog.Logger.Info().Msg("Getting page of comments...")
 
-	page, err := strconv.Atoi(c.DefaultQuery("page", "0"))
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "page must be a positive number"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+		return
+	}
+
+	req := repository.PageRequest{
+		ResourceID: resourceID,
+		Sort:       c.DefaultQuery("sort", ""),
+		Limit:      limit,
+		Page:       page,
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		req.Cursor, err = repository.DecodeCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
+	comments, next, err := repository.SelectPage(h.DB, req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
 
-	sort := c.DefaultQuery("sort", "")
+	err = repository.LoadAttachments(h.DB, comments)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
 
-	comments, err := repository.SelectPage(h.DB, page, sort)
+	total, err := repository.CountRootComments(h.DB, resourceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
-	pages, err := repository.CountPages(h.DB)
+	nextCursor := ""
+	if next != nil {
+		nextCursor = repository.EncodeCursor(next)
+	}
 
 	c.JSON(http.StatusOK, ginext.H{
-		"comments":   comments,
-		"totalPages": pages,
+		"comments":    comments,
+		"total":       total,
+		"totalPages":  max((total+limit-1)/limit, 1),
+		"next_cursor": nextCursor,
 	})
 }
 
+// DeleteComment удаляет комментарий по токену правки из EditTokenHeader. Администратору токен не нужен.
 func (h *Handler) DeleteComment(c *ginext.Context) {
 	id := c.Param("id")
 
-	err := repository.DeleteComments(h.DB, id)
+	commentUUID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	editToken := c.GetHeader(EditTokenHeader)
+	admin := middleware.IsAdmin(c)
+
+	if editToken == "" && !admin {
+		c.JSON(http.StatusForbidden, ginext.H{"error": EditTokenHeader + " header is required"})
+		return
+	}
+
+	// Ветку, ресурс и файлы вложений нужно узнать до удаления
+	event := h.commentEvent(repository.CommentDeleted, commentUUID)
+	blobKeys := h.commentBlobKeys(commentUUID, false)
+
+	tombstone, err := repository.DeleteComments(h.DB, id, editToken, admin)
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrNotAuthor) {
+		c.JSON(http.StatusForbidden, ginext.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, ginext.H{"deleted": id})
+	h.deleteBlobs(blobKeys)
+
+	if event != nil {
+		event.Tombstone = tombstone
+		h.publishEvent(event)
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"deleted": id, "tombstone": tombstone})
+}
+
+func (h *Handler) PurgeComment(c *ginext.Context) {
+	id := c.Param("id")
+
+	commentUUID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	event := h.commentEvent(repository.CommentDeleted, commentUUID)
+	blobKeys := h.commentBlobKeys(commentUUID, true)
+
+	err = repository.PurgeComment(h.DB, id)
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	h.deleteBlobs(blobKeys)
+	h.publishEvent(event)
+
+	c.JSON(http.StatusOK, ginext.H{"purged": id})
 }
 
 func (h *Handler) SearchComment(c *ginext.Context) {
-	keyword := c.DefaultQuery("query", "null")
+	keyword := c.Query("query")
+	if keyword == "" {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "query is required"})
+		return
+	}
+
+	config := ""
+	if lang := c.Query("lang"); lang != "" {
+		var ok bool
+
+		config, ok = language.Config(lang)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("unsupported lang %q", lang)})
+			return
+		}
+	}
 
-	comments, err := repository.SearchComments(h.DB, keyword)
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "page must be a positive number"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+		return
+	}
+
+	results, total, err := repository.SearchComments(h.DB, keyword, config, limit, (page-1)*limit)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, comments)
+	c.JSON(http.StatusOK, ginext.H{
+		"results":    results,
+		"total":      total,
+		"totalPages": max((total+limit-1)/limit, 1),
+	})
+}
+
+func (h *Handler) GetCommentTree(c *ginext.Context) {
+	id := c.Param("id")
+
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	maxDepth, err := strconv.Atoi(c.DefaultQuery("max_depth", strconv.Itoa(defaultTreeDepth)))
+	if err != nil || maxDepth < 0 || maxDepth > maxTreeDepth {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("max_depth must be between 0 and %d", maxTreeDepth)})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultTreeLimit)))
+	if err != nil || limit < 1 || limit > maxTreeLimit {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxTreeLimit)})
+		return
+	}
+
+	tree, err := repository.SelectTree(h.DB, id, maxDepth, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if tree == nil {
+		c.JSON(http.StatusNotFound, ginext.H{"error": "comment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+func (h *Handler) UpdateComment(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	editComment := &EditComment{}
+
+	err = json.Unmarshal(data, editComment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if editComment.Author == "" {
+		editComment.Author = "anonymous"
+	}
+
+	if len(editComment.Author) > maxAuthorLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "author is too long"})
+		return
+	}
+
+	// Имя автора может указать кто угодно, поэтому право на правку подтверждает только токен
+	if editComment.EditToken == "" {
+		c.JSON(http.StatusForbidden, ginext.H{"error": "edit_token is required"})
+		return
+	}
+
+	// Язык мог смениться вместе с текстом, поэтому он определяется заново
+	lang := language.Detect(editComment.Text)
+	if editComment.Lang != "" {
+		var ok bool
+
+		lang, ok = language.Config(editComment.Lang)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("unsupported lang %q", editComment.Lang)})
+			return
+		}
+	}
+
+	// Отклоненная правка не сохраняется, прежний текст остается
+	verdict := h.Moderator.Evaluate(editComment.Text)
+	if verdict.Status == moderation.StatusRejected {
+		c.JSON(http.StatusUnprocessableEntity, ginext.H{"status": verdict.Status, "reasons": verdict.Reasons})
+		return
+	}
+
+	html, err := markdown.Render(editComment.Text)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	comment, previous, err := repository.UpdateComment(h.DB, id, &repository.CommentEdit{
+		Author:    editComment.Author,
+		EditToken: editComment.EditToken,
+		Text:      editComment.Text,
+		HTML:      html,
+		Language:  lang,
+		Status:    verdict.Status,
+		Action:    autoModerationAction(id, verdict),
+	})
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrNotAuthor) || errors.Is(err, repository.ErrCommentRejected) {
+		c.JSON(http.StatusForbidden, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	// Статус мог остаться строже вердикта, если комментарий уже ждал модератора.
+	// Опубликованный комментарий, который после правки ждет проверки, пропадает из открытых
+	// обсуждений, иначе в них останется прежний текст.
+	switch {
+	case comment.Status == moderation.StatusPublished:
+		h.publishEvent(h.commentEvent(repository.CommentEdited, id))
+	case previous == moderation.StatusPublished:
+		h.publishEvent(h.commentEvent(repository.CommentDeleted, id))
+	}
+
+	h.unfurlPreview(id, comment.Text, comment.Preview)
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// GetRevisions отдает прошлые версии комментария. У неопубликованного комментария их видят
+// только автор с токеном правки и администратор, для остальных комментария нет.
+func (h *Handler) GetRevisions(c *ginext.Context) {
+	id := c.Param("id")
+
+	commentUUID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	status, isAuthor, err := repository.CheckEditToken(h.DB, commentUUID, c.GetHeader(EditTokenHeader))
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if status != moderation.StatusPublished && !isAuthor && !middleware.IsAdmin(c) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": repository.ErrCommentNotFound.Error()})
+		return
+	}
+
+	revisions, err := repository.SelectRevisions(h.DB, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
 }
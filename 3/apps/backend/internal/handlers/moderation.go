@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Kost0/L3/internal/moderation"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+)
+
+type ModerationDecision struct {
+	Moderator string `json:"moderator"`
+	Reason    string `json:"reason"`
+}
+
+// pageParams читает page и limit для административных списков
+func pageParams(c *ginext.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		return 0, 0, errors.New("page must be a positive number")
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+
+	return limit, (page - 1) * limit, nil
+}
+
+func (h *Handler) GetPendingComments(c *ginext.Context) {
+	limit, offset, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	comments, err := repository.SelectPending(h.DB, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, comments)
+}
+
+func (h *Handler) ApproveComment(c *ginext.Context) {
+	h.moderate(c, moderation.StatusPublished)
+}
+
+func (h *Handler) RejectComment(c *ginext.Context) {
+	h.moderate(c, moderation.StatusRejected)
+}
+
+func (h *Handler) moderate(c *ginext.Context, status string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	decision := &ModerationDecision{}
+
+	err = json.Unmarshal(data, decision)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if decision.Moderator == "" || len(decision.Moderator) > maxAuthorLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "moderator is required"})
+		return
+	}
+
+	if status == moderation.StatusRejected && decision.Reason == "" {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "reason is required"})
+		return
+	}
+
+	comment, previous, err := repository.ModerateComment(h.DB, id, status, decision.Moderator, decision.Reason)
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrStatusUnchanged) {
+		c.JSON(http.StatusConflict, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	// Для читателей одобренный комментарий появляется только сейчас,
+	// а снятый с публикации пропадает из открытых обсуждений
+	switch {
+	case status == moderation.StatusPublished:
+		h.publishEvent(h.commentEvent(repository.CommentCreated, id))
+	case previous == moderation.StatusPublished:
+		h.publishEvent(h.commentEvent(repository.CommentDeleted, id))
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+func (h *Handler) GetModerationLog(c *ginext.Context) {
+	limit, offset, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	actions, err := repository.SelectModerationLog(h.DB, c.Query("comment_id"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, actions)
+}
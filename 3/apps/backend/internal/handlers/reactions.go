@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+)
+
+// ReactionTokenHeader — заголовок с токеном, к которому привязаны реакции клиента.
+// Токен выдается в этом же заголовке ответа на первую реакцию без него.
+const ReactionTokenHeader = "X-Reaction-Token"
+
+type GetReaction struct {
+	Type string `json:"type"`
+}
+
+func (h *Handler) AddReaction(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	reaction := &GetReaction{}
+
+	err = json.Unmarshal(data, reaction)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if !repository.ValidReaction(reaction.Type) {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": repository.ErrUnknownReaction.Error()})
+		return
+	}
+
+	token := c.GetHeader(ReactionTokenHeader)
+	if token == "" {
+		token, err = h.issueReactionToken(c)
+		if err != nil {
+			return
+		}
+	}
+
+	comment, err := repository.AddReaction(h.DB, id, repository.HashToken(token), reaction.Type)
+	h.respondReaction(c, comment, err)
+}
+
+// issueReactionToken выдает новый токен реакций с учетом лимита на адрес.
+// Если выдать не удалось, ответ уже записан.
+func (h *Handler) issueReactionToken(c *ginext.Context) (string, error) {
+	decision, err := h.Limiter.CheckReactionToken(c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return "", err
+	}
+
+	if !decision.Allowed {
+		retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, ginext.H{"error": decision.Reason, "retry_after": retryAfter})
+		return "", errors.New(decision.Reason)
+	}
+
+	token, _, err := repository.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return "", err
+	}
+
+	c.Header(ReactionTokenHeader, token)
+
+	return token, nil
+}
+
+// RemoveReaction снимает реакцию, поставленную с тем же токеном
+func (h *Handler) RemoveReaction(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid comment id"})
+		return
+	}
+
+	token := c.GetHeader(ReactionTokenHeader)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, ginext.H{"error": ReactionTokenHeader + " header is required"})
+		return
+	}
+
+	comment, err := repository.RemoveReaction(h.DB, id, repository.HashToken(token), c.Param("type"))
+	h.respondReaction(c, comment, err)
+}
+
+func (h *Handler) respondReaction(c *ginext.Context, comment *repository.Comment, err error) {
+	if errors.Is(err, repository.ErrUnknownReaction) {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
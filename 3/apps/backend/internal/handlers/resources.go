@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/wb-go/wbf/ginext"
+)
+
+type ResourceSettings struct {
+	Locked bool `json:"locked"`
+}
+
+func (h *Handler) GetResourceComments(c *ginext.Context) {
+	h.respondPage(c, c.Param("id"))
+}
+
+func (h *Handler) GetResource(c *ginext.Context) {
+	resource, err := repository.SelectResource(h.DB, c.Param("id"))
+	if errors.Is(err, repository.ErrResourceNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resource)
+}
+
+func (h *Handler) UpdateResource(c *ginext.Context) {
+	id := c.Param("id")
+
+	if len(id) > maxResourceLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "resource id is too long"})
+		return
+	}
+
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	settings := &ResourceSettings{}
+
+	err = json.Unmarshal(data, settings)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	err = repository.SetResourceLocked(h.DB, id, settings.Locked)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"id": id, "locked": settings.Locked})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Kost0/L3/internal/netguard"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+)
+
+const maxWebhookURLLength = 2048
+
+// SubscriptionTokenHeader — заголовок с токеном, выданным при создании подписки.
+// С ним же можно создать следующую подписку, чтобы управлять ими вместе.
+const SubscriptionTokenHeader = "X-Subscription-Token"
+
+type GetSubscription struct {
+	User       string  `json:"user"`
+	ResourceID *string `json:"resource_id"`
+	URL        string  `json:"url"`
+	Secret     string  `json:"secret"`
+}
+
+// checkWebhookURL принимает только абсолютные http(s) адреса, которые сейчас не ведут во внутреннюю сеть.
+// Отправитель проверяет адрес еще раз при каждом соединении.
+func (h *Handler) checkWebhookURL(ctx context.Context, raw string) error {
+	if len(raw) > maxWebhookURLLength {
+		return errors.New("url is too long")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+
+	if h.AllowPrivateWebhooks {
+		return nil
+	}
+
+	err = netguard.CheckHost(ctx, u.Hostname())
+	if errors.Is(err, netguard.ErrPrivateAddress) {
+		return errors.New("url must not point to a private address")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot resolve url host: %w", err)
+	}
+
+	return nil
+}
+
+// subscriptionToken возвращает хеш токена из заголовка или пишет ответ 401, если токена нет
+func subscriptionToken(c *ginext.Context) (string, bool) {
+	token := c.GetHeader(SubscriptionTokenHeader)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, ginext.H{"error": SubscriptionTokenHeader + " header is required"})
+		return "", false
+	}
+
+	return repository.HashToken(token), true
+}
+
+func (h *Handler) CreateSubscription(c *ginext.Context) {
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	getSubscription := &GetSubscription{}
+
+	err = json.Unmarshal(data, getSubscription)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if getSubscription.User == "" || len(getSubscription.User) > maxAuthorLength {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "user is required and must be at most 100 characters"})
+		return
+	}
+
+	if getSubscription.ResourceID != nil && (*getSubscription.ResourceID == "" || len(*getSubscription.ResourceID) > maxResourceLength) {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid resource_id"})
+		return
+	}
+
+	err = h.checkWebhookURL(c.Request.Context(), getSubscription.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	token := c.GetHeader(SubscriptionTokenHeader)
+	tokenHash := repository.HashToken(token)
+
+	if token == "" {
+		token, tokenHash, err = repository.NewToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
+	subUUID := uuid.New()
+
+	sub := &repository.Subscription{
+		UUID:       &subUUID,
+		User:       getSubscription.User,
+		ResourceID: getSubscription.ResourceID,
+		URL:        getSubscription.URL,
+		Secret:     getSubscription.Secret,
+		TokenHash:  tokenHash,
+		CreatedAt:  time.Now(),
+	}
+
+	err = repository.CreateSubscription(h.DB, sub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	sub.Token = token
+
+	c.JSON(http.StatusOK, sub)
+}
+
+// GetSubscriptions возвращает подписки, созданные с токеном из заголовка
+func (h *Handler) GetSubscriptions(c *ginext.Context) {
+	tokenHash, ok := subscriptionToken(c)
+	if !ok {
+		return
+	}
+
+	subs, err := repository.SelectSubscriptions(h.DB, tokenHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, subs)
+}
+
+func (h *Handler) DeleteSubscription(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid id"})
+		return
+	}
+
+	tokenHash, ok := subscriptionToken(c)
+	if !ok {
+		return
+	}
+
+	err = repository.DeleteSubscription(h.DB, id, tokenHash)
+	if errors.Is(err, repository.ErrSubscriptionNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"deleted": id})
+}
+
+func (h *Handler) GetDeliveries(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid id"})
+		return
+	}
+
+	limit, offset, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	tokenHash, ok := subscriptionToken(c)
+	if !ok {
+		return
+	}
+
+	err = repository.CheckSubscriptionToken(h.DB, id, tokenHash)
+	if errors.Is(err, repository.ErrSubscriptionNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	deliveries, err := repository.SelectDeliveries(h.DB, id, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
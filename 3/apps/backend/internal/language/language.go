@@ -0,0 +1,49 @@
+package language
+
+import "unicode"
+
+const (
+	Russian = "russian"
+	English = "english"
+	Simple  = "simple"
+)
+
+// configs сопоставляет код языка из запроса конфигурации полнотекстового поиска Postgres
+var configs = map[string]string{
+	"ru":     Russian,
+	"en":     English,
+	"de":     "german",
+	"fr":     "french",
+	"es":     "spanish",
+	"simple": Simple,
+}
+
+// Config возвращает конфигурацию поиска для кода языка
+func Config(code string) (string, bool) {
+	config, ok := configs[code]
+	return config, ok
+}
+
+// Detect определяет язык текста по преобладающему алфавиту.
+// Для латиницы выбирается английский, тексты без букв индексируются без стемминга.
+func Detect(text string) string {
+	var cyrillic, latin int
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	switch {
+	case cyrillic == 0 && latin == 0:
+		return Simple
+	case cyrillic >= latin:
+		return Russian
+	default:
+		return English
+	}
+}
@@ -0,0 +1,94 @@
+package live
+
+import (
+	"sync"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Размер буфера подписчика. Если клиент не успевает читать, он отключается
+// и при переподключении заново загружает обсуждение.
+const subscriberBuffer = 32
+
+func RootTopic(id uuid.UUID) string {
+	return "comment:" + id.String()
+}
+
+func ResourceTopic(id string) string {
+	return "resource:" + id
+}
+
+// Hub рассылает события обсуждений подписчикам этой реплики
+type Hub struct {
+	mu     sync.Mutex
+	topics map[string]map[chan *repository.CommentEvent]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		topics: make(map[string]map[chan *repository.CommentEvent]struct{}),
+	}
+}
+
+// Subscribe возвращает канал событий темы и функцию отписки.
+// Канал закрывается после отписки или если подписчик отстал.
+func (h *Hub) Subscribe(topic string) (<-chan *repository.CommentEvent, func()) {
+	ch := make(chan *repository.CommentEvent, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[chan *repository.CommentEvent]struct{})
+	}
+	h.topics[topic][ch] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.remove(topic, ch)
+	}
+
+	return ch, unsubscribe
+}
+
+// Broadcast отправляет событие подписчикам ветки и всего обсуждения ресурса
+func (h *Hub) Broadcast(event *repository.CommentEvent) {
+	topics := []string{ResourceTopic(event.ResourceID)}
+	if event.RootID != nil {
+		topics = append(topics, RootTopic(*event.RootID))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, topic := range topics {
+		for ch := range h.topics[topic] {
+			select {
+			case ch <- event:
+			default:
+				h.remove(topic, ch)
+			}
+		}
+	}
+}
+
+// remove вызывается под h.mu, поэтому канал закрывается ровно один раз
+func (h *Hub) remove(topic string, ch chan *repository.CommentEvent) {
+	subscribers, ok := h.topics[topic]
+	if !ok {
+		return
+	}
+
+	if _, ok = subscribers[ch]; !ok {
+		return
+	}
+
+	delete(subscribers, ch)
+	close(ch)
+
+	if len(subscribers) == 0 {
+		delete(h.topics, topic)
+	}
+}
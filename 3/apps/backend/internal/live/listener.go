@@ -0,0 +1,96 @@
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/lib/pq"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	pingInterval         = 90 * time.Second
+)
+
+// Listener получает события всех реплик через LISTEN и передает их в Hub.
+// Каждая реплика, включая ту, что создала событие, рассылает его только своим клиентам.
+type Listener struct {
+	DB      *dbpg.DB
+	ConnStr string
+	Hub     *Hub
+}
+
+func NewListener(db *dbpg.DB, connStr string, hub *Hub) *Listener {
+	return &Listener{
+		DB:      db,
+		ConnStr: connStr,
+		Hub:     hub,
+	}
+}
+
+// Start подписывается на канал событий и обрабатывает уведомления, пока процесс работает.
+// При потере соединения pq.Listener переподключается сам, события за время разрыва теряются.
+func (l *Listener) Start() {
+	listener := pq.NewListener(l.ConnStr, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			zlog.Logger.Error().Msgf("Comment events listener: %v", err)
+		}
+	})
+
+	err := listener.Listen(repository.CommentEventsChannel)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error listening to %s: %v", repository.CommentEventsChannel, err)
+		return
+	}
+
+	zlog.Logger.Info().Msgf("Listening to %s", repository.CommentEventsChannel)
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			// nil приходит после переподключения
+			if notification != nil {
+				l.handle(notification.Extra)
+			}
+		case <-time.After(pingInterval):
+			go func() {
+				_ = listener.Ping()
+			}()
+		}
+	}
+}
+
+func (l *Listener) handle(payload string) {
+	event := &repository.CommentEvent{}
+
+	err := json.Unmarshal([]byte(payload), event)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error decoding comment event: %v", err)
+		return
+	}
+
+	if event.Type == repository.CommentCreated || event.Type == repository.CommentEdited {
+		event.Comment, err = repository.SelectPublishedComment(l.DB, event.CommentID)
+		if errors.Is(err, repository.ErrCommentNotFound) {
+			// Комментарий успели удалить или скрыть, пока событие шло по каналу
+			return
+		}
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error loading comment %s for event: %v", event.CommentID, err)
+			return
+		}
+
+		err = repository.LoadAttachments(l.DB, []*repository.Comment{event.Comment})
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error loading attachments of %s for event: %v", event.CommentID, err)
+			return
+		}
+	}
+
+	l.Hub.Broadcast(event)
+}
@@ -0,0 +1,73 @@
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+const linkRel = "nofollow ugc"
+
+// relTransformer помечает все ссылки из комментариев как пользовательские
+type relTransformer struct{}
+
+func (t *relTransformer) Transform(node *ast.Document, _ text.Reader, _ parser.Context) {
+	_ = ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch link := n.(type) {
+		case *ast.Link:
+			link.SetAttributeString("rel", []byte(linkRel))
+		case *ast.AutoLink:
+			link.SetAttributeString("rel", []byte(linkRel))
+		}
+
+		return ast.WalkContinue, nil
+	})
+}
+
+// Сырой HTML в тексте goldmark не пропускает, а политика дополнительно
+// оставляет только теги форматирования и безопасные ссылки
+var (
+	renderer = goldmark.New(
+		goldmark.WithExtensions(extension.Linkify),
+		goldmark.WithParserOptions(
+			parser.WithASTTransformers(util.Prioritized(&relTransformer{}, 100)),
+		),
+	)
+
+	policy = newPolicy()
+)
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements("p", "br", "strong", "em", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("rel").Matching(regexp.MustCompile(`^` + linkRel + `$`)).OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+
+	return p
+}
+
+// Render переводит Markdown комментария в HTML, который можно вставлять на страницу как есть
+func Render(source string) (string, error) {
+	var buf bytes.Buffer
+
+	err := renderer.Convert([]byte(source), &buf)
+	if err != nil {
+		return "", err
+	}
+
+	return policy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wb-go/wbf/ginext"
+)
+
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// AdminMiddleware пропускает только запросы с токеном администратора в заголовке Authorization.
+// Если ADMIN_TOKEN не задан, административные маршруты недоступны.
+func AdminMiddleware() ginext.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+
+		token, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok || adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin token required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IsAdmin сообщает, что запрос пришел с токеном администратора. Нужна для открытых маршрутов,
+// где администратору доступно больше, чем остальным.
+func IsAdmin(c *gin.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || adminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
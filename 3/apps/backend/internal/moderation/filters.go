@@ -0,0 +1,161 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ContentFilter оценивает текст комментария. Score 0 означает, что нарушений нет,
+// чем больше значение, тем подозрительнее текст. Reason объясняет оценку модератору.
+type ContentFilter interface {
+	Name() string
+	Score(text string) (float64, string)
+}
+
+// LengthFilter отклоняет пустые и слишком длинные комментарии
+type LengthFilter struct {
+	Min int
+	Max int
+}
+
+func (f *LengthFilter) Name() string {
+	return "length"
+}
+
+func (f *LengthFilter) Score(text string) (float64, string) {
+	length := utf8.RuneCountInString(strings.TrimSpace(text))
+
+	if length < f.Min {
+		return RejectScore, fmt.Sprintf("text is shorter than %d characters", f.Min)
+	}
+
+	if f.Max > 0 && length > f.Max {
+		return RejectScore, fmt.Sprintf("text is longer than %d characters", f.Max)
+	}
+
+	return 0, ""
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinkFilter отправляет на проверку комментарии с большим числом ссылок
+type LinkFilter struct {
+	MaxLinks int
+}
+
+func (f *LinkFilter) Name() string {
+	return "links"
+}
+
+func (f *LinkFilter) Score(text string) (float64, string) {
+	links := len(linkPattern.FindAllString(text, -1))
+	if links <= f.MaxLinks {
+		return 0, ""
+	}
+
+	return float64(links - f.MaxLinks), fmt.Sprintf("%d links, at most %d allowed", links, f.MaxLinks)
+}
+
+// ProfanityFilter ищет слова, начинающиеся с одной из основ из списка
+type ProfanityFilter struct {
+	Words []string
+}
+
+func (f *ProfanityFilter) Name() string {
+	return "profanity"
+}
+
+func (f *ProfanityFilter) Score(text string) (float64, string) {
+	found := make([]string, 0)
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		for _, stem := range f.Words {
+			if strings.HasPrefix(word, stem) {
+				found = append(found, word)
+				break
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return 0, ""
+	}
+
+	return float64(len(found)), fmt.Sprintf("profanity: %s", strings.Join(found, ", "))
+}
+
+// SpamFilter ловит типичные признаки спама: капс, длинные повторы символов и одно слово, повторенное много раз
+type SpamFilter struct{}
+
+func (f *SpamFilter) Name() string {
+	return "spam"
+}
+
+func (f *SpamFilter) Score(text string) (float64, string) {
+	var score float64
+	reasons := make([]string, 0)
+
+	var letters, upper int
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+
+	if letters >= 20 && float64(upper)/float64(letters) > 0.7 {
+		score += 0.5
+		reasons = append(reasons, "mostly capital letters")
+	}
+
+	if longestRun(text) >= 10 {
+		score += 0.5
+		reasons = append(reasons, "repeated characters")
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), isSeparator)
+	if len(words) >= 6 {
+		counts := make(map[string]int)
+		top := 0
+
+		for _, word := range words {
+			counts[word]++
+			top = max(top, counts[word])
+		}
+
+		if top*2 > len(words) {
+			score += 1
+			reasons = append(reasons, "repeated words")
+		}
+	}
+
+	return score, strings.Join(reasons, ", ")
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func longestRun(text string) int {
+	longest, current := 0, 0
+	var prev rune
+
+	for i, r := range text {
+		if i > 0 && r == prev {
+			current++
+		} else {
+			current = 1
+		}
+
+		longest = max(longest, current)
+		prev = r
+	}
+
+	return longest
+}
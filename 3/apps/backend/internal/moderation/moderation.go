@@ -0,0 +1,84 @@
+package moderation
+
+import (
+	"strings"
+)
+
+const (
+	StatusPublished = "published"
+	StatusPending   = "pending"
+	StatusRejected  = "rejected"
+
+	// PendingScore — суммарная оценка, начиная с которой комментарий ждет модератора
+	PendingScore = 1.0
+	// RejectScore — суммарная оценка, начиная с которой комментарий отклоняется сразу
+	RejectScore = 3.0
+)
+
+// DefaultProfanity — основы слов для фильтра по умолчанию, дополняются через PROFANITY_WORDS
+var DefaultProfanity = []string{"fuck", "shit", "bitch", "хуй", "хуе", "пизд", "ебат", "ебан", "бляд", "сука"}
+
+type Verdict struct {
+	Status  string   `json:"status"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// Moderator прогоняет текст через все фильтры и решает, публиковать ли комментарий
+type Moderator struct {
+	Filters []ContentFilter
+}
+
+func NewModerator(filters ...ContentFilter) *Moderator {
+	return &Moderator{
+		Filters: filters,
+	}
+}
+
+// NewDefaultModerator собирает стандартный набор фильтров, extraWords — дополнительные основы ругательств через запятую
+func NewDefaultModerator(extraWords string) *Moderator {
+	words := append([]string{}, DefaultProfanity...)
+
+	for _, word := range strings.Split(extraWords, ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			words = append(words, word)
+		}
+	}
+
+	return NewModerator(
+		&LengthFilter{Min: 1, Max: 5000},
+		&LinkFilter{MaxLinks: 2},
+		&ProfanityFilter{Words: words},
+		&SpamFilter{},
+	)
+}
+
+func (m *Moderator) Evaluate(text string) Verdict {
+	verdict := Verdict{
+		Status:  StatusPublished,
+		Reasons: make([]string, 0),
+	}
+
+	for _, filter := range m.Filters {
+		score, reason := filter.Score(text)
+		if score <= 0 {
+			continue
+		}
+
+		verdict.Score += score
+		verdict.Reasons = append(verdict.Reasons, filter.Name()+": "+reason)
+	}
+
+	switch {
+	case verdict.Score >= RejectScore:
+		verdict.Status = StatusRejected
+	case verdict.Score >= PendingScore:
+		verdict.Status = StatusPending
+	}
+
+	return verdict
+}
+
+func (v Verdict) Reason() string {
+	return strings.Join(v.Reasons, "; ")
+}
@@ -0,0 +1,51 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+var ErrPrivateAddress = errors.New("destination resolves to a private address")
+
+// Общее адресное пространство провайдеров (RFC 6598), net.IP.IsPrivate его не учитывает
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublic сообщает, что адрес можно использовать для исходящих запросов по ссылкам пользователей
+func IsPublic(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// CheckAddress подходит для net.Dialer.Control: проверяется адрес, с которым действительно
+// устанавливается соединение, поэтому подмена DNS после проверки ссылки не помогает
+func CheckAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !IsPublic(net.ParseIP(host)) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// CheckHost проверяет все адреса, в которые сейчас разрешается имя хоста
+func CheckHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if !IsPublic(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/Kost0/L3/internal/netguard"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const (
+	defaultBatchSize   = 50
+	defaultMaxAttempts = 8
+	defaultTimeout     = 10 * time.Second
+	retryBaseDelay     = 10 * time.Second
+	retryMaxDelay      = time.Hour
+)
+
+// Queue — очередь доставки, из которой Dispatcher берет события и в которую записывает результат
+type Queue interface {
+	Claim(limit int, lease time.Duration) ([]*repository.Delivery, error)
+	MarkDelivered(id *uuid.UUID) error
+	Retry(id *uuid.UUID, lastError string, delay time.Duration) error
+	Fail(id *uuid.UUID, lastError string) error
+}
+
+// dbQueue хранит очередь в таблице webhook_delivery
+type dbQueue struct {
+	db *dbpg.DB
+}
+
+func (q *dbQueue) Claim(limit int, lease time.Duration) ([]*repository.Delivery, error) {
+	return repository.ClaimDeliveries(q.db, limit, lease)
+}
+
+func (q *dbQueue) MarkDelivered(id *uuid.UUID) error {
+	return repository.MarkDelivered(q.db, id)
+}
+
+func (q *dbQueue) Retry(id *uuid.UUID, lastError string, delay time.Duration) error {
+	return repository.RetryDelivery(q.db, id, lastError, delay)
+}
+
+func (q *dbQueue) Fail(id *uuid.UUID, lastError string) error {
+	return repository.FailDelivery(q.db, id, lastError)
+}
+
+// Dispatcher отправляет события из очереди подписчикам.
+// AllowPrivate разрешает локальные адреса, например для httptest-сервера.
+type Dispatcher struct {
+	Queue        Queue
+	Client       *http.Client
+	Interval     time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	AllowPrivate bool
+}
+
+func NewDispatcher(db *dbpg.DB, interval time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		Queue:       &dbQueue{db: db},
+		Interval:    interval,
+		BatchSize:   defaultBatchSize,
+		MaxAttempts: defaultMaxAttempts,
+	}
+
+	dialer := &net.Dialer{
+		Timeout: defaultTimeout,
+		Control: d.checkAddress,
+	}
+
+	d.Client = &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   defaultTimeout,
+			ResponseHeaderTimeout: defaultTimeout,
+		},
+		// Редирект считается ошибкой доставки: подписчик должен указать конечный адрес
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return d
+}
+
+// checkAddress запрещает доставку во внутреннюю сеть: адрес подписки задает кто угодно,
+// и без проверки через него можно обращаться к сервисам рядом с сервером
+func (d *Dispatcher) checkAddress(network, address string, conn syscall.RawConn) error {
+	if d.AllowPrivate {
+		return nil
+	}
+
+	return netguard.CheckAddress(network, address, conn)
+}
+
+// Start проверяет очередь при запуске и затем каждые Interval.
+// Неудачная доставка повторяется с экспоненциальной задержкой, после MaxAttempts попыток событие
+// помечается как failed.
+func (d *Dispatcher) Start() {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch()
+
+		<-ticker.C
+	}
+}
+
+func (d *Dispatcher) dispatch() {
+	// Lease больше таймаута клиента, чтобы событие не взяли повторно, пока идет запрос
+	deliveries, err := d.Queue.Claim(d.BatchSize, 2*d.Client.Timeout+d.Interval)
+	if err != nil {
+		zlog.Logger.Error().Msgf("Error claiming webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		err = d.send(delivery)
+		if err == nil {
+			err = d.Queue.MarkDelivered(delivery.UUID)
+			if err != nil {
+				zlog.Logger.Error().Msgf("Error marking delivery %s: %v", delivery.UUID, err)
+			}
+
+			continue
+		}
+
+		zlog.Logger.Warn().Msgf("Delivery %s attempt %d failed: %v", delivery.UUID, delivery.Attempts, err)
+
+		if delivery.Attempts >= d.MaxAttempts {
+			err = d.Queue.Fail(delivery.UUID, err.Error())
+		} else {
+			err = d.Queue.Retry(delivery.UUID, err.Error(), RetryDelay(delivery.Attempts))
+		}
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error rescheduling delivery %s: %v", delivery.UUID, err)
+		}
+	}
+}
+
+func (d *Dispatcher) send(delivery *repository.Delivery) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.UUID.String())
+
+	if delivery.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Payload))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// RetryDelay возвращает задержку перед следующей попыткой: 10s, 20s, 40s и так далее, но не больше часа
+func RetryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// Sign возвращает подпись тела запроса в формате "sha256=<hex>", подписчик может проверить ее своим секретом
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
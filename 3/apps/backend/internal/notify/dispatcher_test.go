@@ -0,0 +1,311 @@
+package notify
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kost0/L3/internal/netguard"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+)
+
+// fakeQueue хранит очередь в памяти: отложенное событие снова выдается при следующем Claim
+type fakeQueue struct {
+	pending   []*repository.Delivery
+	byID      map[uuid.UUID]*repository.Delivery
+	delivered []uuid.UUID
+	delays    []time.Duration
+	errors    []string
+	failed    []uuid.UUID
+}
+
+func newFakeQueue(deliveries ...*repository.Delivery) *fakeQueue {
+	q := &fakeQueue{byID: make(map[uuid.UUID]*repository.Delivery)}
+
+	for _, delivery := range deliveries {
+		q.byID[*delivery.UUID] = delivery
+		q.pending = append(q.pending, delivery)
+	}
+
+	return q
+}
+
+func (q *fakeQueue) Claim(limit int, _ time.Duration) ([]*repository.Delivery, error) {
+	claimed := q.pending[:min(limit, len(q.pending))]
+	q.pending = q.pending[len(claimed):]
+
+	for _, delivery := range claimed {
+		delivery.Attempts++
+	}
+
+	return claimed, nil
+}
+
+func (q *fakeQueue) MarkDelivered(id *uuid.UUID) error {
+	q.delivered = append(q.delivered, *id)
+	return nil
+}
+
+func (q *fakeQueue) Retry(id *uuid.UUID, lastError string, delay time.Duration) error {
+	q.delays = append(q.delays, delay)
+	q.errors = append(q.errors, lastError)
+	q.pending = append(q.pending, q.byID[*id])
+
+	return nil
+}
+
+func (q *fakeQueue) Fail(id *uuid.UUID, lastError string) error {
+	q.failed = append(q.failed, *id)
+	q.errors = append(q.errors, lastError)
+
+	return nil
+}
+
+func newDelivery(url, secret string) *repository.Delivery {
+	id := uuid.New()
+
+	return &repository.Delivery{
+		UUID:    &id,
+		Event:   repository.EventReply,
+		Payload: []byte(`{"event":"reply","user":"alice"}`),
+		Status:  repository.DeliveryPending,
+		URL:     url,
+		Secret:  secret,
+	}
+}
+
+func newTestDispatcher(queue Queue) *Dispatcher {
+	d := NewDispatcher(nil, time.Second)
+	d.Queue = queue
+	d.AllowPrivate = true
+
+	return d
+}
+
+func TestDispatcherDeliversSignedEvent(t *testing.T) {
+	delivery := newDelivery("", "s3cret")
+
+	var received atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+
+		if string(body) != string(delivery.Payload) {
+			t.Errorf("body = %s, want %s", body, delivery.Payload)
+		}
+
+		if got, want := r.Header.Get(SignatureHeader), Sign("s3cret", body); got != want {
+			t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+		}
+
+		if got := r.Header.Get(EventHeader); got != repository.EventReply {
+			t.Errorf("%s = %q, want %q", EventHeader, got, repository.EventReply)
+		}
+
+		if got := r.Header.Get(DeliveryHeader); got != delivery.UUID.String() {
+			t.Errorf("%s = %q, want %q", DeliveryHeader, got, delivery.UUID)
+		}
+
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	delivery.URL = server.URL
+	queue := newFakeQueue(delivery)
+
+	newTestDispatcher(queue).dispatch()
+
+	if received.Load() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", received.Load())
+	}
+
+	if len(queue.delivered) != 1 || queue.delivered[0] != *delivery.UUID {
+		t.Fatalf("delivered = %v, want [%s]", queue.delivered, delivery.UUID)
+	}
+}
+
+func TestDispatcherWithoutSecretSendsNoSignature(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig := r.Header.Get(SignatureHeader); sig != "" {
+			t.Errorf("unexpected %s %q", SignatureHeader, sig)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	queue := newFakeQueue(newDelivery(server.URL, ""))
+
+	newTestDispatcher(queue).dispatch()
+
+	if len(queue.delivered) != 1 {
+		t.Fatalf("delivered = %v, want one delivery", queue.delivered)
+	}
+}
+
+func TestDispatcherRetriesServerErrorWithBackoff(t *testing.T) {
+	var received atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Первые две попытки падают, третья проходит
+		if received.Add(1) <= 2 {
+			http.Error(w, "try again later", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	delivery := newDelivery(server.URL, "s3cret")
+	queue := newFakeQueue(delivery)
+	d := newTestDispatcher(queue)
+
+	for range 3 {
+		d.dispatch()
+	}
+
+	if received.Load() != 3 {
+		t.Fatalf("receiver got %d requests, want 3", received.Load())
+	}
+
+	wantDelays := []time.Duration{RetryDelay(1), RetryDelay(2)}
+	if len(queue.delays) != len(wantDelays) {
+		t.Fatalf("retries = %v, want %v", queue.delays, wantDelays)
+	}
+
+	for i, delay := range wantDelays {
+		if queue.delays[i] != delay {
+			t.Errorf("retry %d delay = %s, want %s", i+1, queue.delays[i], delay)
+		}
+
+		if !strings.Contains(queue.errors[i], "500") {
+			t.Errorf("retry %d error = %q, want the status", i+1, queue.errors[i])
+		}
+	}
+
+	if len(queue.delivered) != 1 || len(queue.failed) != 0 {
+		t.Fatalf("delivered = %v, failed = %v, want one delivery", queue.delivered, queue.failed)
+	}
+}
+
+func TestDispatcherFailsAfterMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	delivery := newDelivery(server.URL, "")
+	queue := newFakeQueue(delivery)
+	d := newTestDispatcher(queue)
+	d.MaxAttempts = 3
+
+	for range d.MaxAttempts {
+		d.dispatch()
+	}
+
+	if len(queue.delays) != d.MaxAttempts-1 {
+		t.Fatalf("retries = %d, want %d", len(queue.delays), d.MaxAttempts-1)
+	}
+
+	if len(queue.failed) != 1 || queue.failed[0] != *delivery.UUID {
+		t.Fatalf("failed = %v, want [%s]", queue.failed, delivery.UUID)
+	}
+
+	// После отказа событие больше не выдается
+	d.dispatch()
+
+	if len(queue.failed) != 1 || len(queue.delivered) != 0 {
+		t.Fatalf("failed = %v, delivered = %v after giving up", queue.failed, queue.delivered)
+	}
+}
+
+func TestDispatcherDoesNotFollowRedirects(t *testing.T) {
+	var target atomic.Int32
+
+	final := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer final.Close()
+
+	server := httptest.NewServer(http.RedirectHandler(final.URL, http.StatusTemporaryRedirect))
+	defer server.Close()
+
+	queue := newFakeQueue(newDelivery(server.URL, ""))
+
+	newTestDispatcher(queue).dispatch()
+
+	if target.Load() != 0 {
+		t.Fatal("redirect was followed")
+	}
+
+	if len(queue.delays) != 1 {
+		t.Fatalf("retries = %v, want one retry", queue.delays)
+	}
+}
+
+func TestDispatcherRejectsPrivateAddress(t *testing.T) {
+	var received atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	delivery := newDelivery(server.URL, "")
+	queue := newFakeQueue(delivery)
+	d := newTestDispatcher(queue)
+	d.AllowPrivate = false
+
+	err := d.send(delivery)
+	if !errors.Is(err, netguard.ErrPrivateAddress) {
+		t.Fatalf("send to %s: err = %v, want %v", server.URL, err, netguard.ErrPrivateAddress)
+	}
+
+	d.dispatch()
+
+	if received.Load() != 0 {
+		t.Fatalf("receiver got %d requests, want none", received.Load())
+	}
+
+	if len(queue.delays) != 1 || len(queue.delivered) != 0 {
+		t.Fatalf("delays = %v, delivered = %v, want one retry", queue.delays, queue.delivered)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
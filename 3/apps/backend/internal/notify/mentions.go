@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxMentions ограничивает число уведомлений, которые может вызвать один комментарий
+const MaxMentions = 20
+
+const maxUserLength = 100
+
+// Упоминание начинается с @ в начале текста или после символа, который не может быть частью
+// имени или адреса почты, поэтому "mail@example.com" упоминанием не считается
+var mentionRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@-])@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// ParseMentions возвращает имена упомянутых пользователей без повторов в порядке появления в тексте
+func ParseMentions(text string) []string {
+	mentions := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, match := range mentionRe.FindAllStringSubmatch(text, -1) {
+		// Точка или дефис в конце обычно относятся к предложению, а не к имени
+		user := strings.TrimRight(match[1], ".-")
+		if user == "" || len(user) > maxUserLength || seen[user] {
+			continue
+		}
+
+		seen[user] = true
+		mentions = append(mentions, user)
+
+		if len(mentions) == MaxMentions {
+			break
+		}
+	}
+
+	return mentions
+}
@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// Имя по умолчанию общее для всех, поэтому анонимные авторы различаются по IP
+const anonymousAuthor = "anonymous"
+
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+type Config struct {
+	PerIP           Rule
+	PerAuthor       Rule
+	DuplicateWindow time.Duration
+	ReplyInterval   time.Duration
+	// ReactionTokens ограничивает выдачу токенов реакций одному адресу, иначе голоса
+	// можно накручивать, получая новый токен на каждый голос
+	ReactionTokens Rule
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PerIP:           Rule{Limit: 20, Window: time.Minute},
+		PerAuthor:       Rule{Limit: 5, Window: time.Minute},
+		DuplicateWindow: 10 * time.Minute,
+		ReplyInterval:   15 * time.Second,
+		ReactionTokens:  Rule{Limit: 10, Window: time.Hour},
+	}
+}
+
+// Request — данные нового комментария, по которым проверяются ограничения
+type Request struct {
+	IP     string
+	Author string
+	Parent *uuid.UUID
+	Text   string
+}
+
+// Decision — результат проверки. claims — ключи, занятые разрешенным запросом,
+// их нужно освободить через Release, если комментарий так и не сохранился.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Reason     string
+	claims     []claim
+}
+
+type claim struct {
+	key       string
+	expiresAt time.Time
+}
+
+type Limiter struct {
+	Store  Store
+	Config Config
+}
+
+func NewLimiter(store Store, config Config) *Limiter {
+	return &Limiter{
+		Store:  store,
+		Config: config,
+	}
+}
+
+// Check проверяет по порядку: лимит по IP, лимит по автору, интервал между ответами одному
+// родителю и повтор текста. Проверки, прошедшие до отказа, уже учтены, так что попытки
+// флуда тоже расходуют лимит. Check вызывается после проверки самого комментария, чтобы
+// исправленный после ошибки запрос не считался повтором.
+func (l *Limiter) Check(req Request) (Decision, error) {
+	// Postgres хранит время с точностью до микросекунд, иначе Release не найдет занятый ключ
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	identity := "author:" + req.Author
+	if req.Author == anonymousAuthor {
+		identity = "ip:" + req.IP
+	}
+
+	retryAfter, err := l.Store.Hit("ip:"+req.IP, now, l.Config.PerIP.Limit, l.Config.PerIP.Window)
+	if err != nil || retryAfter > 0 {
+		return deny(retryAfter, "too many comments from this address"), err
+	}
+
+	if req.Author != anonymousAuthor {
+		retryAfter, err = l.Store.Hit(identity, now, l.Config.PerAuthor.Limit, l.Config.PerAuthor.Window)
+		if err != nil || retryAfter > 0 {
+			return deny(retryAfter, "too many comments from this author"), err
+		}
+	}
+
+	decision := Decision{Allowed: true}
+
+	if req.Parent != nil {
+		key := "reply:" + identity + ":" + req.Parent.String()
+
+		retryAfter, err = l.Store.Claim(key, now, l.Config.ReplyInterval)
+		if err != nil || retryAfter > 0 {
+			return deny(retryAfter, "replying to the same comment too often"), err
+		}
+
+		decision.claims = append(decision.claims, claim{key: key, expiresAt: now.Add(l.Config.ReplyInterval)})
+	}
+
+	key := "dup:" + identity + ":" + contentHash(req.Text)
+
+	retryAfter, err = l.Store.Claim(key, now, l.Config.DuplicateWindow)
+	if err != nil || retryAfter > 0 {
+		l.Release(decision)
+		return deny(retryAfter, "duplicate comment"), err
+	}
+
+	decision.claims = append(decision.claims, claim{key: key, expiresAt: now.Add(l.Config.DuplicateWindow)})
+
+	return decision, nil
+}
+
+// CheckReactionToken учитывает выдачу токена реакций адресу ip
+func (l *Limiter) CheckReactionToken(ip string) (Decision, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	retryAfter, err := l.Store.Hit("reaction-token:"+ip, now, l.Config.ReactionTokens.Limit, l.Config.ReactionTokens.Window)
+	if err != nil || retryAfter > 0 {
+		return deny(retryAfter, "too many reaction tokens for this address"), err
+	}
+
+	return Decision{Allowed: true}, nil
+}
+
+// Release освобождает ключи повтора и ответа, занятые Check, если комментарий не удалось сохранить.
+// Учтенные запросы в лимитах по IP и автору остаются.
+func (l *Limiter) Release(decision Decision) {
+	for _, c := range decision.claims {
+		err := l.Store.Release(c.key, c.expiresAt)
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error releasing rate limit key %s: %v", c.key, err)
+		}
+	}
+}
+
+// StartCleanup периодически удаляет устаревшие записи хранилища
+func (l *Limiter) StartCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now().UTC()
+
+		err := l.Store.Cleanup(now, now.Add(-l.longestWindow()))
+		if err != nil {
+			zlog.Logger.Error().Msgf("Error cleaning up rate limits: %v", err)
+		}
+	}
+}
+
+func (l *Limiter) longestWindow() time.Duration {
+	return max(l.Config.PerIP.Window, l.Config.PerAuthor.Window, l.Config.ReactionTokens.Window)
+}
+
+func deny(retryAfter time.Duration, reason string) Decision {
+	return Decision{
+		RetryAfter: retryAfter,
+		Reason:     reason,
+	}
+}
+
+// contentHash не различает регистр и пробельные символы, чтобы мелкие правки не обходили проверку
+func contentHash(text string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// Store хранит состояние ограничений. Память подходит для одной реплики,
+// Postgres — для нескольких реплик за балансировщиком.
+type Store interface {
+	// Hit учитывает запрос в скользящем окне, если в нем меньше limit запросов.
+	// Если лимит исчерпан, запрос не учитывается и возвращается время до освобождения места.
+	Hit(key string, now time.Time, limit int, window time.Duration) (time.Duration, error)
+	// Claim занимает ключ на ttl. Если ключ уже занят, возвращает оставшееся время.
+	Claim(key string, now time.Time, ttl time.Duration) (time.Duration, error)
+	// Release освобождает ключ, занятый до expiresAt. Ключ, который с тех пор заняли заново, не трогается.
+	Release(key string, expiresAt time.Time) error
+	// Cleanup удаляет запросы старше hitsBefore и истекшие ключи
+	Cleanup(now, hitsBefore time.Time) error
+}
+
+// NewStore возвращает хранилище по имени: memory или postgres
+func NewStore(kind string, db *dbpg.DB) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "postgres":
+		return &PostgresStore{DB: db}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", kind)
+	}
+}
+
+type MemoryStore struct {
+	mu     sync.Mutex
+	hits   map[string][]time.Time
+	claims map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		hits:   make(map[string][]time.Time),
+		claims: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Hit(key string, now time.Time, limit int, window time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hits := s.hits[key]
+
+	// Запросы хранятся по возрастанию времени, вышедшие из окна отбрасываются с начала
+	start := now.Add(-window)
+	i := 0
+	for i < len(hits) && !hits[i].After(start) {
+		i++
+	}
+	hits = hits[i:]
+
+	if len(hits) >= limit {
+		s.hits[key] = hits
+		return hits[0].Add(window).Sub(now), nil
+	}
+
+	s.hits[key] = append(hits, now)
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Claim(key string, now time.Time, ttl time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expiresAt, ok := s.claims[key]; ok && expiresAt.After(now) {
+		return expiresAt.Sub(now), nil
+	}
+
+	s.claims[key] = now.Add(ttl)
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Release(key string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.claims[key]; ok && current.Equal(expiresAt) {
+		delete(s.claims, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Cleanup(now, hitsBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, hits := range s.hits {
+		if len(hits) == 0 || !hits[len(hits)-1].After(hitsBefore) {
+			delete(s.hits, key)
+		}
+	}
+
+	for key, expiresAt := range s.claims {
+		if !expiresAt.After(now) {
+			delete(s.claims, key)
+		}
+	}
+
+	return nil
+}
+
+type PostgresStore struct {
+	DB *dbpg.DB
+}
+
+func (s *PostgresStore) Hit(key string, now time.Time, limit int, window time.Duration) (time.Duration, error) {
+	return repository.RecordRateLimitHit(s.DB, key, now, limit, window)
+}
+
+func (s *PostgresStore) Claim(key string, now time.Time, ttl time.Duration) (time.Duration, error) {
+	return repository.ClaimRateLimitKey(s.DB, key, now, ttl)
+}
+
+func (s *PostgresStore) Release(key string, expiresAt time.Time) error {
+	return repository.ReleaseRateLimitKey(s.DB, key, expiresAt)
+}
+
+func (s *PostgresStore) Cleanup(now, hitsBefore time.Time) error {
+	return repository.DeleteExpiredRateLimits(s.DB, now, hitsBefore)
+}
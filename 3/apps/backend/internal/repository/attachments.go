@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+const attachmentColumns = `a.id, a.comment_id, a.blob_key, a.file_name, a.content_type, a.size, a.width, a.height, a.created_at`
+
+func scanAttachment(row interface{ Scan(dest ...any) error }) (*Attachment, error) {
+	attachment := &Attachment{}
+
+	err := row.Scan(&attachment.UUID, &attachment.CommentID, &attachment.Key, &attachment.FileName,
+		&attachment.ContentType, &attachment.Size, &attachment.Width, &attachment.Height, &attachment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.URL = "/attachments/" + attachment.UUID.String()
+
+	return attachment, nil
+}
+
+// LoadAttachments заполняет Attachments у переданных комментариев одним запросом
+func LoadAttachments(db *dbpg.DB, comments []*Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*Comment, len(comments))
+	ids := make([]string, 0, len(comments))
+
+	for _, comment := range comments {
+		comment.Attachments = make([]*Attachment, 0)
+		byID[*comment.UUID] = comment
+		ids = append(ids, comment.UUID.String())
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachment a
+WHERE a.comment_id = ANY($1::uuid[])
+ORDER BY a.created_at, a.id`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+
+		if comment, ok := byID[*attachment.CommentID]; ok {
+			comment.Attachments = append(comment.Attachments, attachment)
+		}
+	}
+
+	return rows.Err()
+}
+
+// SelectAttachment возвращает вложение опубликованного комментария
+func SelectAttachment(db *dbpg.DB, id uuid.UUID) (*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachment a
+JOIN comment c ON c.id = a.comment_id
+WHERE a.id = $1 AND c.status = 'published'`
+
+	attachment, err := scanAttachment(db.QueryRowContext(context.Background(), query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAttachmentNotFound
+	}
+
+	return attachment, err
+}
+
+// SelectAttachmentKeys возвращает ключи файлов комментария, а при subtree — и всех ответов на него.
+// Записи удаляются вместе с комментарием каскадно, файлы из хранилища удаляет вызывающий.
+func SelectAttachmentKeys(db *dbpg.DB, id uuid.UUID, subtree bool) ([]string, error) {
+	query := `WITH RECURSIVE thread AS (
+	SELECT id FROM comment WHERE id = $1
+	UNION ALL
+	SELECT c.id FROM comment c JOIN thread t ON c.parent = t.id WHERE $2::boolean
+)
+SELECT a.blob_key FROM attachment a JOIN thread t ON a.comment_id = t.id`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, id, subtree)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	keys := make([]string, 0)
+
+	for rows.Next() {
+		var key string
+
+		err = rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// SetCommentPreview сохраняет карточку ссылки, nil удаляет ее
+func SetCommentPreview(db *dbpg.DB, id uuid.UUID, preview *LinkPreview) error {
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy,
+		`UPDATE comment SET preview = $1 WHERE id = $2 AND deleted_at IS NULL`, preview, id)
+
+	return err
+}
@@ -9,27 +9,30 @@ import (
 	"github.com/wb-go/wbf/zlog"
 )
 
-func ConnectDB() (*dbpg.DB, error) {
+// ConnString собирает строку подключения из переменных окружения
+func ConnString() string {
 	dbHost := os.Getenv("DB_HOST")
 	dbUser := os.Getenv("DB_USER")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	dbName := os.Getenv("DB_NAME")
 	dbPort := os.Getenv("DB_PORT")
 
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbPassword, dbName)
+}
+
+func ConnectDB() (*dbpg.DB, error) {
 	opts := &dbpg.Options{
 		MaxOpenConns:    10,
 		MaxIdleConns:    5,
 		ConnMaxLifetime: 10 * time.Second,
 	}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbPassword, dbName)
-
-	db, err := dbpg.New(connStr, []string{}, opts)
+	db, err := dbpg.New(ConnString(), []string{}, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	zlog.Logger.Info().Msg("Connected to database: " + dbName)
+	zlog.Logger.Info().Msg("Connected to database: " + os.Getenv("DB_NAME"))
 
 	return db, nil
 }
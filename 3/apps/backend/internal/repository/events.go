@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// CommentEventsChannel — канал LISTEN/NOTIFY, через который реплики обмениваются событиями обсуждений
+const CommentEventsChannel = "comment_events"
+
+const (
+	CommentCreated = "created"
+	CommentEdited  = "edited"
+	CommentDeleted = "deleted"
+)
+
+// SelectCommentThread возвращает событие без типа с родителем, корнем ветки и ресурсом комментария
+func SelectCommentThread(db *dbpg.DB, id uuid.UUID) (*CommentEvent, error) {
+	query := `WITH RECURSIVE chain AS (
+	SELECT id, parent, resource_id, parent AS origin_parent FROM comment WHERE id = $1
+	UNION ALL
+	SELECT c.id, c.parent, c.resource_id, chain.origin_parent
+	FROM comment c
+	JOIN chain ON c.id = chain.parent
+)
+SELECT id, origin_parent, resource_id FROM chain WHERE parent IS NULL`
+
+	event := &CommentEvent{CommentID: &id}
+
+	err := db.QueryRowContext(context.Background(), query, id).Scan(&event.RootID, &event.ParentID, &event.ResourceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// SelectPublishedComment возвращает опубликованный комментарий по id
+func SelectPublishedComment(db *dbpg.DB, id *uuid.UUID) (*Comment, error) {
+	comment, err := scanComment(db.QueryRowContext(context.Background(),
+		`SELECT `+commentColumns+` FROM comment WHERE id = $1 AND status = 'published'`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+
+	return comment, err
+}
+
+// PublishCommentEvent рассылает событие всем репликам через NOTIFY. Текст комментария
+// в уведомление не входит из-за ограничения размера, получатели читают его из базы.
+func PublishCommentEvent(db *dbpg.DB, event *CommentEvent) error {
+	notification := *event
+	notification.Comment = nil
+
+	payload, err := json.Marshal(&notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(context.Background(), `SELECT pg_notify($1, $2)`, CommentEventsChannel, string(payload))
+
+	return err
+}
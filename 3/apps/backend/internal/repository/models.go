@@ -1,12 +1,224 @@
 package repository
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type Comment struct {
-	UUID   *uuid.UUID `json:"id"`
-	Text   string     `json:"text"`
-	Parent *uuid.UUID `json:"parent"`
-	Vector string     `json:"search_vector"`
+	UUID        *uuid.UUID     `json:"id"`
+	Text        string         `json:"text"`
+	HTML        string         `json:"html"`
+	Parent      *uuid.UUID     `json:"parent"`
+	Vector      string         `json:"search_vector"`
+	Author      string         `json:"author"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   *time.Time     `json:"updated_at"`
+	DeletedAt   *time.Time     `json:"deleted_at"`
+	ResourceID  string         `json:"resource_id"`
+	Likes       int            `json:"likes"`
+	Dislikes    int            `json:"dislikes"`
+	Reactions   ReactionCounts `json:"reactions"`
+	Language    string         `json:"language"`
+	Status      string         `json:"status"`
+	Preview     *LinkPreview   `json:"preview"`
+	Attachments []*Attachment  `json:"attachments"`
+	// EditTokenHash — хеш токена, который автор предъявляет при редактировании
+	EditTokenHash string `json:"-"`
+}
+
+// CommentEdit — новая версия комментария. Action — решение фильтров, которое записывается
+// в журнал модерации вместе с правкой, nil означает, что фильтры ничего не нашли.
+type CommentEdit struct {
+	Author    string
+	EditToken string
+	Text      string
+	HTML      string
+	Language  string
+	Status    string
+	Action    *ModerationAction
+}
+
+// LinkPreview — карточка первой ссылки из текста комментария, хранится в JSONB-колонке preview
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+}
+
+func (p *LinkPreview) Scan(src any) error {
+	var data []byte
+
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported preview type %T", src)
+	}
+
+	return json.Unmarshal(data, p)
+}
+
+func (p *LinkPreview) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Attachment — изображение, прикрепленное к комментарию. Файл лежит в хранилище по ключу Key,
+// а клиенту отдается по адресу URL.
+type Attachment struct {
+	UUID        *uuid.UUID `json:"id"`
+	CommentID   *uuid.UUID `json:"comment_id"`
+	Key         string     `json:"-"`
+	FileName    string     `json:"file_name"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Width       *int       `json:"width"`
+	Height      *int       `json:"height"`
+	URL         string     `json:"url"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CommentNode — комментарий внутри дерева ответов.
+// HasMore означает, что у комментария есть ответы, не вошедшие в выдачу из-за лимитов.
+type CommentNode struct {
+	Comment
+	Depth    int            `json:"depth"`
+	HasMore  bool           `json:"has_more"`
+	Children []*CommentNode `json:"children"`
+}
+
+// Revision — предыдущая версия текста комментария
+type Revision struct {
+	UUID       *uuid.UUID `json:"id"`
+	CommentID  *uuid.UUID `json:"comment_id"`
+	Text       string     `json:"text"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReplacedAt time.Time  `json:"replaced_at"`
+}
+
+// Resource — обсуждение, к которому привязаны комментарии (статья, товар, тикет)
+type Resource struct {
+	ID           string `json:"id"`
+	Locked       bool   `json:"locked"`
+	Comments     int    `json:"comments"`
+	RootComments int    `json:"root_comments"`
+}
+
+// SearchResult — найденный комментарий с подсвеченным фрагментом и цепочкой родителей от корня
+type SearchResult struct {
+	Comment   *Comment   `json:"comment"`
+	Snippet   string     `json:"snippet"`
+	Rank      float64    `json:"rank"`
+	Ancestors []*Comment `json:"ancestors"`
+}
+
+// ModerationAction — запись журнала модерации, Moderator "auto" означает решение фильтров
+type ModerationAction struct {
+	UUID      *uuid.UUID `json:"id"`
+	CommentID *uuid.UUID `json:"comment_id"`
+	Action    string     `json:"action"`
+	Moderator string     `json:"moderator"`
+	Reason    string     `json:"reason"`
+	Score     float64    `json:"score"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Subscription — адрес, на который отправляются уведомления об ответах и упоминаниях пользователя.
+// Пустой ResourceID означает подписку на все обсуждения. Token отдается только при создании,
+// в базе хранится его хеш.
+type Subscription struct {
+	UUID       *uuid.UUID `json:"id"`
+	User       string     `json:"user"`
+	ResourceID *string    `json:"resource_id"`
+	URL        string     `json:"url"`
+	Secret     string     `json:"-"`
+	Token      string     `json:"token,omitempty"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NotificationEvent — тело запроса, которое получает подписчик. Подписаться можно от имени
+// любого пользователя, поэтому в событии только идентификаторы: сам комментарий подписчик
+// читает через API, где видны только опубликованные комментарии.
+type NotificationEvent struct {
+	Event      string     `json:"event"`
+	User       string     `json:"user"`
+	CommentID  *uuid.UUID `json:"comment_id"`
+	ParentID   *uuid.UUID `json:"parent_id"`
+	ResourceID string     `json:"resource_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Delivery — попытка доставить событие подписчику
+type Delivery struct {
+	UUID           *uuid.UUID      `json:"id"`
+	SubscriptionID *uuid.UUID      `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
+
+// CommentEvent — изменение комментария, которое получают открытые обсуждения.
+// Comment заполняется для created и edited, Tombstone — для удаления с заменой на заглушку.
+type CommentEvent struct {
+	Type       string     `json:"type"`
+	CommentID  *uuid.UUID `json:"comment_id"`
+	ParentID   *uuid.UUID `json:"parent_id"`
+	RootID     *uuid.UUID `json:"root_id"`
+	ResourceID string     `json:"resource_id"`
+	Tombstone  bool       `json:"tombstone,omitempty"`
+	Comment    *Comment   `json:"comment,omitempty"`
+}
+
+// ReactionCounts — число эмодзи-реакций каждого типа, хранится в JSONB-колонке reactions
+type ReactionCounts map[string]int
+
+func (r *ReactionCounts) Scan(src any) error {
+	var data []byte
+
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*r = ReactionCounts{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported reactions type %T", src)
+	}
+
+	counts := ReactionCounts{}
+
+	err := json.Unmarshal(data, &counts)
+	if err != nil {
+		return err
+	}
+
+	*r = counts
+
+	return nil
 }
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var ErrStatusUnchanged = errors.New("comment already has this status")
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertModerationAction(db execer, action *ModerationAction) error {
+	query := `INSERT INTO moderation_action (id, comment_id, action, moderator, reason, score, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := db.ExecContext(context.Background(), query, action.UUID, action.CommentID, action.Action,
+		action.Moderator, action.Reason, action.Score, action.CreatedAt)
+
+	return err
+}
+
+// SelectPending возвращает комментарии, ожидающие модерации, начиная со старых
+func SelectPending(db *dbpg.DB, limit, offset int) ([]*Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comment
+WHERE status = 'pending'
+ORDER BY created_at, id
+LIMIT $1 OFFSET $2`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	zlog.Logger.Info().Msg("Getting pending comments")
+
+	res := make([]*Comment, 0)
+
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, comment)
+	}
+
+	return res, nil
+}
+
+// ModerateComment публикует или отклоняет комментарий и записывает решение в журнал в той же
+// транзакции. Отклонить можно и уже опубликованный комментарий. При публикации подписчикам
+// уходят отложенные уведомления об ответе и упоминаниях. Возвращает комментарий и его прежний статус.
+func ModerateComment(db *dbpg.DB, id uuid.UUID, status, moderator, reason string) (*Comment, string, error) {
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ctx := context.Background()
+
+	var previous string
+
+	err = tx.QueryRowContext(ctx, `SELECT status FROM comment WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if previous == status {
+		return nil, "", ErrStatusUnchanged
+	}
+
+	comment, err := scanComment(tx.QueryRowContext(ctx, `UPDATE comment SET status = $1 WHERE id = $2 RETURNING `+commentColumns, status, id))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if status == "published" {
+		mentions, err := selectMentions(ctx, tx, id)
+		if err != nil {
+			return nil, "", err
+		}
+
+		err = enqueueNotifications(ctx, tx, comment, mentions)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	actionUUID := uuid.New()
+
+	err = insertModerationAction(tx, &ModerationAction{
+		UUID:      &actionUUID,
+		CommentID: &id,
+		Action:    status,
+		Moderator: moderator,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	zlog.Logger.Info().Msgf("Comment %s moderated: %s", id, status)
+
+	return comment, previous, nil
+}
+
+// SelectModerationLog возвращает журнал модерации, пустой commentID означает все комментарии
+func SelectModerationLog(db *dbpg.DB, commentID string, limit, offset int) ([]*ModerationAction, error) {
+	query := `SELECT id, comment_id, action, moderator, reason, score, created_at FROM moderation_action
+WHERE ($1 = '' OR comment_id::text = $1)
+ORDER BY created_at DESC, id
+LIMIT $2 OFFSET $3`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, commentID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	res := make([]*ModerationAction, 0)
+
+	for rows.Next() {
+		action := &ModerationAction{}
+		err = rows.Scan(
+			&action.UUID,
+			&action.CommentID,
+			&action.Action,
+			&action.Moderator,
+			&action.Reason,
+			&action.Score,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, action)
+	}
+
+	return res, nil
+}
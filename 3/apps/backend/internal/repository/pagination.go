@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// pageOrder описывает сортировку корневых комментариев: выражение ключа, тип его значения
+// в курсоре и направление. При равных ключах порядок задает id в том же направлении.
+type pageOrder struct {
+	expr string
+	cast string
+	desc bool
+}
+
+// Выражение hot зависит от текущего времени, поэтому вместо NOW() используется момент
+// из курсора (подставляется на место %s), чтобы порядок не менялся между страницами
+var pageOrders = map[string]pageOrder{
+	"asc":  {expr: "text", cast: "text"},
+	"desc": {expr: "text", cast: "text", desc: true},
+	"new":  {expr: "created_at", cast: "timestamp", desc: true},
+	"old":  {expr: "created_at", cast: "timestamp"},
+	"top":  {expr: "(likes - dislikes)", cast: "bigint", desc: true},
+	// Много голосов и близкое число лайков и дизлайков
+	"controversial": {expr: `(CASE WHEN likes = 0 OR dislikes = 0 THEN 0
+	ELSE POWER(likes + dislikes, LEAST(likes, dislikes)::float / GREATEST(likes, dislikes)) END)::float8`, cast: "float8", desc: true},
+	// Рейтинг затухает со временем, как на Hacker News
+	"hot": {expr: "((likes - dislikes) / POWER(EXTRACT(EPOCH FROM %s - created_at) / 3600 + 2, 1.5))::float8", cast: "float8", desc: true},
+}
+
+// PageCursor указывает на последний комментарий страницы.
+// Now фиксирует момент первой страницы для сортировок, зависящих от времени.
+type PageCursor struct {
+	Key  string    `json:"k"`
+	ID   uuid.UUID `json:"id"`
+	Sort string    `json:"s"`
+	Now  time.Time `json:"n"`
+}
+
+func EncodeCursor(cursor *PageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(value string) (*PageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &PageCursor{}
+
+	err = json.Unmarshal(data, cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+type PageRequest struct {
+	ResourceID string
+	Sort       string
+	Limit      int
+	// Page используется, только если не передан Cursor
+	Page   int
+	Cursor *PageCursor
+}
+
+// SelectPage возвращает страницу корневых комментариев и курсор следующей страницы (nil, если страница последняя).
+// С курсором выборка идет по ключу сортировки и не зависит от глубины страницы.
+// Пустой ResourceID означает все обсуждения.
+func SelectPage(db *dbpg.DB, req PageRequest) ([]*Comment, *PageCursor, error) {
+	if req.Sort == "" {
+		req.Sort = "old"
+	}
+
+	order, ok := pageOrders[req.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort %q", req.Sort)
+	}
+
+	now := time.Now()
+	if req.Cursor != nil {
+		if req.Cursor.Sort != req.Sort {
+			return nil, nil, ErrInvalidCursor
+		}
+
+		now = req.Cursor.Now
+	}
+
+	args := make([]any, 0, 6)
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	key := order.expr
+	if req.Sort == "hot" {
+		key = fmt.Sprintf(order.expr, arg(now)+"::timestamp")
+	}
+
+	direction, compare := "", ">"
+	if order.desc {
+		direction, compare = " DESC", "<"
+	}
+
+	resource := arg(req.ResourceID)
+
+	query := fmt.Sprintf(`SELECT %s, (%s)::text FROM comment
+WHERE parent IS NULL AND deleted_at IS NULL AND status = 'published' AND (%s = '' OR resource_id = %s)`, commentColumns, key, resource, resource)
+
+	if req.Cursor != nil {
+		query += fmt.Sprintf(` AND ((%s), id) %s (%s::%s, %s)`, key, compare, arg(req.Cursor.Key), order.cast, arg(req.Cursor.ID))
+	}
+
+	query += fmt.Sprintf(` ORDER BY (%s)%s, id%s LIMIT %s`, key, direction, direction, arg(req.Limit+1))
+
+	if req.Cursor == nil {
+		query += fmt.Sprintf(` OFFSET %s`, arg((max(req.Page, 1)-1)*req.Limit))
+	}
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	zlog.Logger.Info().Msg("Getting comments")
+
+	res := make([]*Comment, 0, req.Limit+1)
+	keys := make([]string, 0, req.Limit+1)
+
+	for rows.Next() {
+		comment := &Comment{}
+
+		var sortKey string
+
+		err = rows.Scan(append(commentFields(comment), &sortKey)...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		res = append(res, comment)
+		keys = append(keys, sortKey)
+	}
+
+	// Лишняя строка означает, что есть следующая страница
+	if len(res) <= req.Limit {
+		return res, nil, nil
+	}
+
+	res = res[:req.Limit]
+	last := res[len(res)-1]
+
+	return res, &PageCursor{Key: keys[len(res)-1], ID: *last.UUID, Sort: req.Sort, Now: now}, nil
+}
+
+// CountRootComments считает опубликованные корневые комментарии без учета удаленных
+func CountRootComments(db *dbpg.DB, resourceID string) (int, error) {
+	query := `SELECT COUNT(*) FROM comment
+WHERE parent IS NULL AND deleted_at IS NULL AND status = 'published' AND ($1 = '' OR resource_id = $1)`
+
+	var count int
+
+	err := db.QueryRowContext(context.Background(), query, resourceID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
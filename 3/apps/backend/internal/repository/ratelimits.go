@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// RecordRateLimitHit учитывает запрос в скользящем окне ключа, если в окне меньше limit запросов.
+// Иначе возвращает время, через которое освободится самое старое место в окне.
+// Запросы с одним ключом сериализуются advisory-блокировкой, чтобы реплики не превысили лимит вместе.
+func RecordRateLimitHit(db *dbpg.DB, key string, now time.Time, limit int, window time.Duration) (time.Duration, error) {
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ctx := context.Background()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM rate_limit_hit WHERE key = $1 AND at <= $2`, key, now.Add(-window))
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	var oldest sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*), MIN(at) FROM rate_limit_hit WHERE key = $1`, key).Scan(&count, &oldest)
+	if err != nil {
+		return 0, err
+	}
+
+	if count >= limit {
+		return oldest.Time.Add(window).Sub(now), nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO rate_limit_hit (key, at) VALUES ($1, $2)`, key, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, tx.Commit()
+}
+
+// ClaimRateLimitKey занимает ключ до now+ttl, если он свободен или срок прошлой записи истек.
+// Если ключ занят, возвращает оставшееся время.
+func ClaimRateLimitKey(db *dbpg.DB, key string, now time.Time, ttl time.Duration) (time.Duration, error) {
+	query := `INSERT INTO rate_limit_claim (key, expires_at) VALUES ($1, $2)
+ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at
+WHERE rate_limit_claim.expires_at <= $3
+RETURNING key`
+
+	ctx := context.Background()
+
+	var claimed string
+
+	err := db.QueryRowContext(ctx, query, key, now.Add(ttl), now).Scan(&claimed)
+	if err == nil {
+		return 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	var expiresAt time.Time
+
+	err = db.QueryRowContext(ctx, `SELECT expires_at FROM rate_limit_claim WHERE key = $1`, key).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Запись успели удалить между запросами, повторная попытка пройдет
+		return time.Second, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return expiresAt.Sub(now), nil
+}
+
+// ReleaseRateLimitKey освобождает ключ, если он все еще занят до expiresAt
+func ReleaseRateLimitKey(db *dbpg.DB, key string, expiresAt time.Time) error {
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, `DELETE FROM rate_limit_claim
+WHERE key = $1 AND expires_at = $2`, key, expiresAt)
+
+	return err
+}
+
+// DeleteExpiredRateLimits удаляет запросы старше hitsBefore и истекшие ключи
+func DeleteExpiredRateLimits(db *dbpg.DB, now, hitsBefore time.Time) error {
+	ctx := context.Background()
+
+	_, err := db.ExecWithRetry(ctx, retryStrategy, `DELETE FROM rate_limit_hit WHERE at <= $1`, hitsBefore)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecWithRetry(ctx, retryStrategy, `DELETE FROM rate_limit_claim WHERE expires_at <= $1`, now)
+
+	return err
+}
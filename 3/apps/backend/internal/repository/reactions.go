@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+)
+
+// EmojiReactions — допустимые эмодзи-реакции помимо лайков и дизлайков
+var EmojiReactions = map[string]bool{
+	"heart": true,
+	"laugh": true,
+	"wow":   true,
+	"sad":   true,
+	"angry": true,
+	"party": true,
+}
+
+var ErrUnknownReaction = errors.New("unknown reaction type")
+
+func ValidReaction(reactionType string) bool {
+	return reactionType == ReactionLike || reactionType == ReactionDislike || EmojiReactions[reactionType]
+}
+
+// AddReaction ставит реакцию пользователя и обновляет счетчики комментария в той же транзакции.
+// Лайк и дизлайк взаимоисключающие: новый голос снимает противоположный.
+func AddReaction(db *dbpg.DB, commentID uuid.UUID, userID, reactionType string) (*Comment, error) {
+	if !ValidReaction(reactionType) {
+		return nil, ErrUnknownReaction
+	}
+
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = lockComment(tx, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reactionType {
+	case ReactionLike:
+		err = removeReaction(tx, commentID, userID, ReactionDislike)
+	case ReactionDislike:
+		err = removeReaction(tx, commentID, userID, ReactionLike)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(context.Background(), `INSERT INTO comment_reaction (comment_id, user_id, type, created_at)
+VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, commentID, userID, reactionType, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted > 0 {
+		err = changeReactionCount(tx, commentID, reactionType, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return commitReactions(tx, commentID)
+}
+
+// RemoveReaction снимает реакцию пользователя, если она была
+func RemoveReaction(db *dbpg.DB, commentID uuid.UUID, userID, reactionType string) (*Comment, error) {
+	if !ValidReaction(reactionType) {
+		return nil, ErrUnknownReaction
+	}
+
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = lockComment(tx, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = removeReaction(tx, commentID, userID, reactionType)
+	if err != nil {
+		return nil, err
+	}
+
+	return commitReactions(tx, commentID)
+}
+
+func lockComment(tx *sql.Tx, commentID uuid.UUID) error {
+	var id uuid.UUID
+
+	err := tx.QueryRowContext(context.Background(), `SELECT id FROM comment WHERE id = $1 AND deleted_at IS NULL AND status = 'published' FOR UPDATE`, commentID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCommentNotFound
+	}
+
+	return err
+}
+
+func removeReaction(tx *sql.Tx, commentID uuid.UUID, userID, reactionType string) error {
+	res, err := tx.ExecContext(context.Background(), `DELETE FROM comment_reaction WHERE comment_id = $1 AND user_id = $2 AND type = $3`,
+		commentID, userID, reactionType)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return nil
+	}
+
+	return changeReactionCount(tx, commentID, reactionType, -1)
+}
+
+func changeReactionCount(tx *sql.Tx, commentID uuid.UUID, reactionType string, delta int) error {
+	var query string
+
+	switch reactionType {
+	case ReactionLike:
+		query = `UPDATE comment SET likes = likes + $2 WHERE id = $1`
+	case ReactionDislike:
+		query = `UPDATE comment SET dislikes = dislikes + $2 WHERE id = $1`
+	default:
+		query = `UPDATE comment SET reactions = jsonb_set(reactions, ARRAY[$3::text],
+	to_jsonb(COALESCE((reactions->>$3::text)::int, 0) + $2)) WHERE id = $1`
+
+		_, err := tx.ExecContext(context.Background(), query, commentID, delta, reactionType)
+
+		return err
+	}
+
+	_, err := tx.ExecContext(context.Background(), query, commentID, delta)
+
+	return err
+}
+
+func commitReactions(tx *sql.Tx, commentID uuid.UUID) (*Comment, error) {
+	comment, err := scanComment(tx.QueryRowContext(context.Background(), `SELECT `+commentColumns+` FROM comment WHERE id = $1`, commentID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	zlog.Logger.Info().Msg("Reactions updated")
+
+	return comment, nil
+}
@@ -2,9 +2,13 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"math"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
@@ -16,10 +20,146 @@ var retryStrategy = retry.Strategy{
 	Backoff:  math.Exp(1),
 }
 
-func InsertComment(db *dbpg.DB, comment *Comment) error {
-	query := `INSERT INTO comment(id, text, parent) VALUES ($1, $2, $3)`
+var commentColumnList = []string{
+	"id", "text", "parent", "search_vector", "author", "created_at", "updated_at", "deleted_at", "resource_id",
+	"likes", "dislikes", "reactions", "language", "status", "text_html", "preview",
+}
+
+var commentColumns = strings.Join(commentColumnList, ", ")
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrThreadLocked    = errors.New("thread is locked")
+)
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// aliasedCommentColumns возвращает колонки комментария с префиксом таблицы, например "c.id, c.text, ..."
+func aliasedCommentColumns(alias string) string {
+	columns := make([]string, len(commentColumnList))
+
+	for i, column := range commentColumnList {
+		columns[i] = alias + "." + column
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// commentFields возвращает поля комментария в порядке commentColumnList
+func commentFields(comment *Comment) []any {
+	return []any{
+		&comment.UUID,
+		&comment.Text,
+		&comment.Parent,
+		&comment.Vector,
+		&comment.Author,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.DeletedAt,
+		&comment.ResourceID,
+		&comment.Likes,
+		&comment.Dislikes,
+		&comment.Reactions,
+		&comment.Language,
+		&comment.Status,
+		&comment.HTML,
+		&comment.Preview,
+	}
+}
+
+func scanComment(row scanner) (*Comment, error) {
+	comment := &Comment{}
+
+	err := row.Scan(commentFields(comment)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+// InsertComment сохраняет комментарий в обсуждении ресурса.
+// Ответ всегда попадает в обсуждение родителя, в закрытое обсуждение писать нельзя.
+// Упоминания сохраняются сразу, а уведомления ставятся в очередь только для опубликованного комментария.
+// Решение фильтров action, если оно есть, записывается в журнал в той же транзакции.
+func InsertComment(db *dbpg.DB, comment *Comment, mentions []string, action *ModerationAction) error {
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ctx := context.Background()
+
+	if comment.Parent != nil {
+		err = tx.QueryRowContext(ctx, `SELECT resource_id FROM comment WHERE id = $1 AND status = 'published'`, comment.Parent).Scan(&comment.ResourceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO resource (id) VALUES ($1) ON CONFLICT (id) DO NOTHING`, comment.ResourceID)
+	if err != nil {
+		return err
+	}
+
+	var locked bool
+
+	err = tx.QueryRowContext(ctx, `SELECT locked FROM resource WHERE id = $1 FOR SHARE`, comment.ResourceID).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		return ErrThreadLocked
+	}
+
+	query := `INSERT INTO comment(id, text, parent, author, created_at, resource_id, language, status, text_html, edit_token_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err = tx.ExecContext(ctx, query, comment.UUID, comment.Text, comment.Parent, comment.Author, comment.CreatedAt,
+		comment.ResourceID, comment.Language, comment.Status, comment.HTML, comment.EditTokenHash)
+	if err != nil {
+		return err
+	}
+
+	for _, attachment := range comment.Attachments {
+		_, err = tx.ExecContext(ctx, `INSERT INTO attachment (id, comment_id, blob_key, file_name, content_type, size, width, height, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, attachment.UUID, comment.UUID, attachment.Key, attachment.FileName,
+			attachment.ContentType, attachment.Size, attachment.Width, attachment.Height, attachment.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertMentions(ctx, tx, comment.UUID, mentions)
+	if err != nil {
+		return err
+	}
+
+	if action != nil {
+		err = insertModerationAction(tx, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	if comment.Status == "published" {
+		err = enqueueNotifications(ctx, tx, comment, mentions)
+		if err != nil {
+			return err
+		}
+	}
 
-	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, comment.UUID, comment.Text, comment.Parent)
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
@@ -30,7 +170,7 @@ func InsertComment(db *dbpg.DB, comment *Comment) error {
 }
 
 func SelectRootComments(db *dbpg.DB) ([]*Comment, error) {
-	query := `SELECT * FROM comment WHERE parent IS NULL`
+	query := `SELECT ` + commentColumns + ` FROM comment WHERE parent IS NULL AND status = 'published'`
 
 	zlog.Logger.Info().Msg("Getting comments...")
 
@@ -49,13 +189,7 @@ func SelectRootComments(db *dbpg.DB) ([]*Comment, error) {
 	res := make([]*Comment, 0)
 
 	for rows.Next() {
-		comment := &Comment{}
-		err = rows.Scan(
-			&comment.UUID,
-			&comment.Text,
-			&comment.Parent,
-			&comment.Vector,
-		)
+		comment, err := scanComment(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -73,8 +207,8 @@ func SelectComments(db *dbpg.DB, id string) ([]*Comment, error) {
 		return []*Comment{}, nil
 	}
 
-	query := `SELECT * FROM comment
-	WHERE comment.parent = $1`
+	query := `SELECT ` + commentColumns + ` FROM comment
+	WHERE comment.parent = $1 AND comment.status = 'published'`
 
 	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, id)
 	if err != nil {
@@ -93,13 +227,7 @@ func SelectComments(db *dbpg.DB, id string) ([]*Comment, error) {
 	res := make([]*Comment, 0)
 
 	for rows.Next() {
-		comment := &Comment{}
-		err = rows.Scan(
-			&comment.UUID,
-			&comment.Text,
-			&comment.Parent,
-			&comment.Vector,
-		)
+		comment, err := scanComment(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -110,121 +238,115 @@ func SelectComments(db *dbpg.DB, id string) ([]*Comment, error) {
 	return res, nil
 }
 
-func DeleteComments(db *dbpg.DB, id string) error {
-	query := `DELETE FROM comment WHERE comment.id = $1`
+const deletedText = "[deleted]"
 
-	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, id)
+// DeleteComments удаляет комментарий. Если на него есть ответы, вместо удаления остается
+// заглушка "[deleted]", чтобы не потерять ветку. После удаления листа заодно удаляются
+// заглушки выше по ветке, у которых больше не осталось ответов.
+// Удалить может только тот, кто предъявил токен правки, или администратор, тогда admin равен true.
+// Возвращает true, если комментарий был заменен заглушкой.
+func DeleteComments(db *dbpg.DB, id, editToken string, admin bool) (bool, error) {
+	tx, err := db.Master.Begin()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	zlog.Logger.Info().Msg("Comments deleted")
+	defer func() {
+		_ = tx.Rollback()
+	}()
 
-	return nil
-}
+	ctx := context.Background()
 
-func SearchComments(db *dbpg.DB, keyword string) ([]*Comment, error) {
-	query := `SELECT * FROM comment
-WHERE search_vector @@ to_tsquery('russian', $1)
-ORDER BY ts_rank(search_vector, to_tsquery('russian', $1)) DESC`
+	var parent *uuid.UUID
+	var hasReplies bool
+	var tokenHash sql.NullString
 
-	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, keyword)
+	err = tx.QueryRowContext(ctx, `SELECT parent, EXISTS(SELECT 1 FROM comment r WHERE r.parent = c.id), edit_token_hash
+FROM comment c WHERE c.id = $1 AND c.deleted_at IS NULL FOR UPDATE`, id).Scan(&parent, &hasReplies, &tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrCommentNotFound
+	}
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	defer func() {
-		err = rows.Close()
+	if !admin && !tokenMatches(editToken, tokenHash.String) {
+		return false, ErrNotAuthor
+	}
+
+	if hasReplies {
+		_, err = tx.ExecContext(ctx, `UPDATE comment SET text = $1, author = $1, text_html = $2, preview = NULL, deleted_at = $3 WHERE id = $4`,
+			deletedText, "<p>"+deletedText+"</p>", time.Now(), id)
 		if err != nil {
-			zlog.Logger.Error().Err(err)
+			return false, err
 		}
-	}()
 
-	zlog.Logger.Info().Msg("Getting comments")
-
-	res := make([]*Comment, 0)
-
-	for rows.Next() {
-		comment := &Comment{}
-		err = rows.Scan(
-			&comment.UUID,
-			&comment.Text,
-			&comment.Parent,
-			&comment.Vector,
-		)
+		// Прошлые версии удаленного комментария тоже не должны оставаться доступными
+		_, err = tx.ExecContext(ctx, `DELETE FROM comment_revision WHERE comment_id = $1`, id)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 
-		res = append(res, comment)
-	}
-
-	return res, nil
-}
-
-func SelectPage(db *dbpg.DB, page int, sort string) ([]*Comment, error) {
-	query := `SELECT * FROM comment
-WHERE parent IS NULL
-`
-
-	if sort == "desc" {
-		query += ` ORDER BY text DESC`
-	} else if sort == "asc" {
-		query += ` ORDER BY text`
-	}
-
-	query += ` LIMIT 10 OFFSET ($1 - 1) * 10`
-
-	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, page)
-	if err != nil {
-		return nil, err
-	}
+		_, err = tx.ExecContext(ctx, `DELETE FROM comment_mention WHERE comment_id = $1`, id)
+		if err != nil {
+			return false, err
+		}
 
-	defer func() {
-		err = rows.Close()
+		_, err = tx.ExecContext(ctx, `DELETE FROM attachment WHERE comment_id = $1`, id)
 		if err != nil {
-			zlog.Logger.Error().Err(err)
+			return false, err
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM comment WHERE id = $1`, id)
+		if err != nil {
+			return false, err
 		}
-	}()
 
-	zlog.Logger.Info().Msg("Getting comments")
+		for parent != nil {
+			var next *uuid.UUID
 
-	res := make([]*Comment, 0)
+			err = tx.QueryRowContext(ctx, `DELETE FROM comment c WHERE c.id = $1 AND c.deleted_at IS NOT NULL
+AND NOT EXISTS(SELECT 1 FROM comment r WHERE r.parent = c.id) RETURNING c.parent`, parent).Scan(&next)
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				return false, err
+			}
 
-	for rows.Next() {
-		comment := &Comment{}
-		err = rows.Scan(
-			&comment.UUID,
-			&comment.Text,
-			&comment.Parent,
-			&comment.Vector,
-		)
-		if err != nil {
-			return nil, err
+			parent = next
 		}
+	}
 
-		res = append(res, comment)
+	err = tx.Commit()
+	if err != nil {
+		return false, err
 	}
 
-	return res, nil
+	zlog.Logger.Info().Msg("Comments deleted")
+
+	return hasReplies, nil
 }
 
-func CountPages(db *dbpg.DB) (int, error) {
-	query := `SELECT COUNT(*) FROM comment`
+// PurgeComment окончательно удаляет комментарий вместе со всеми ответами
+func PurgeComment(db *dbpg.DB, id string) error {
+	query := `DELETE FROM comment WHERE comment.id = $1`
 
-	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query)
+	res, err := db.ExecWithRetry(context.Background(), retryStrategy, query, id)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	var count int
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
 
-	for rows.Next() {
-		err = rows.Scan(&count)
-		if err != nil {
-			return 0, err
-		}
+	if affected == 0 {
+		return ErrCommentNotFound
 	}
 
-	return count/10 + 1, nil
+	zlog.Logger.Info().Msg("Comment purged")
+
+	return nil
 }
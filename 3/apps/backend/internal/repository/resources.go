@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var ErrResourceNotFound = errors.New("resource not found")
+
+// SelectResource возвращает настройки обсуждения и число опубликованных комментариев в нем без учета удаленных
+func SelectResource(db *dbpg.DB, id string) (*Resource, error) {
+	query := `SELECT r.id, r.locked,
+	COUNT(c.id) FILTER (WHERE c.deleted_at IS NULL AND c.status = 'published'),
+	COUNT(c.id) FILTER (WHERE c.deleted_at IS NULL AND c.status = 'published' AND c.parent IS NULL)
+FROM resource r
+LEFT JOIN comment c ON c.resource_id = r.id
+WHERE r.id = $1
+GROUP BY r.id, r.locked`
+
+	resource := &Resource{}
+
+	err := db.QueryRowContext(context.Background(), query, id).Scan(
+		&resource.ID,
+		&resource.Locked,
+		&resource.Comments,
+		&resource.RootComments,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrResourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	zlog.Logger.Info().Msg("Got resource")
+
+	return resource, nil
+}
+
+// SetResourceLocked открывает или закрывает обсуждение, создавая его при необходимости
+func SetResourceLocked(db *dbpg.DB, id string, locked bool) error {
+	query := `INSERT INTO resource (id, locked) VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET locked = EXCLUDED.locked`
+
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, id, locked)
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msgf("Resource %s locked: %t", id, locked)
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var (
+	ErrNotAuthor       = errors.New("only the author can change the comment")
+	ErrCommentRejected = errors.New("rejected comment cannot be edited")
+)
+
+// statusRank упорядочивает статусы от открытого к закрытому
+var statusRank = map[string]int{
+	"published": 0,
+	"pending":   1,
+	"rejected":  2,
+}
+
+// stricterStatus возвращает более строгий из двух статусов
+func stricterStatus(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+
+	return a
+}
+
+// UpdateComment меняет текст комментария, сохраняя предыдущую версию в comment_revision.
+// Редактировать может только тот, кто предъявил токен, выданный при создании.
+// Новый текст снова проходит модерацию, но правка может только ужесточить статус: отклоненный
+// комментарий не редактируется, а ожидающий проверки остается ждать модератора.
+// language заново определяется по тексту, если клиент не указал язык.
+// Возвращает новую версию и статус комментария до правки.
+func UpdateComment(db *dbpg.DB, id uuid.UUID, edit *CommentEdit) (*Comment, string, error) {
+	tx, err := db.Master.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ctx := context.Background()
+
+	current := &Comment{}
+	var tokenHash sql.NullString
+
+	err = tx.QueryRowContext(ctx, `SELECT `+commentColumns+`, edit_token_hash FROM comment WHERE id = $1 FOR UPDATE`, id).
+		Scan(append(commentFields(current), &tokenHash)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if current.DeletedAt != nil {
+		return nil, "", ErrCommentNotFound
+	}
+
+	if current.Author != edit.Author || !tokenMatches(edit.EditToken, tokenHash.String) {
+		return nil, "", ErrNotAuthor
+	}
+
+	if current.Status == "rejected" {
+		return nil, "", ErrCommentRejected
+	}
+
+	versionCreatedAt := current.CreatedAt
+	if current.UpdatedAt != nil {
+		versionCreatedAt = *current.UpdatedAt
+	}
+
+	now := time.Now()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO comment_revision (id, comment_id, text, created_at, replaced_at) VALUES ($1, $2, $3, $4, $5)`,
+		uuid.New(), id, current.Text, versionCreatedAt, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	updated, err := scanComment(tx.QueryRowContext(ctx, `UPDATE comment SET text = $1, text_html = $2, updated_at = $3, status = $4, language = $5
+WHERE id = $6 RETURNING `+commentColumns, edit.Text, edit.HTML, now, stricterStatus(current.Status, edit.Status), edit.Language, id))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if edit.Action != nil {
+		err = insertModerationAction(tx, edit.Action)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	zlog.Logger.Info().Msg("Comment updated")
+
+	return updated, current.Status, nil
+}
+
+// SelectRevisions возвращает прошлые версии комментария, начиная с самой новой
+func SelectRevisions(db *dbpg.DB, id string) ([]*Revision, error) {
+	query := `SELECT id, comment_id, text, created_at, replaced_at FROM comment_revision
+WHERE comment_id = $1
+ORDER BY replaced_at DESC`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	zlog.Logger.Info().Msg("Getting revisions")
+
+	res := make([]*Revision, 0)
+
+	for rows.Next() {
+		revision := &Revision{}
+		err = rows.Scan(
+			&revision.UUID,
+			&revision.CommentID,
+			&revision.Text,
+			&revision.CreatedAt,
+			&revision.ReplacedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, revision)
+	}
+
+	return res, nil
+}
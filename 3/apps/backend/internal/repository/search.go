@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// Текст экранируется до подсветки, поэтому в snippet безопасны только теги <mark>
+const headlineOptions = `StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10`
+
+// SearchComments ищет комментарии по запросу в синтаксисе websearch_to_tsquery.
+// Без config запрос разбирается конфигурацией языка каждого комментария, с config — один раз
+// этой конфигурацией, и поиск идет только среди комментариев на этом языке.
+// Возвращает страницу результатов и общее число найденных комментариев.
+func SearchComments(db *dbpg.DB, keyword, config string, limit, offset int) ([]*SearchResult, int, error) {
+	// Запрос, который строится для каждой строки, нельзя искать по GIN-индексу, поэтому
+	// при известном языке он вычисляется один раз, и индекс comment_search_vector_idx работает
+	source := `comment c
+CROSS JOIN LATERAL websearch_to_tsquery(c.language, $1) q`
+	filter := `$2 = ''`
+
+	if config != "" {
+		source = `comment c
+CROSS JOIN websearch_to_tsquery($2::regconfig, $1) q`
+		filter = `c.language = $2::regconfig`
+	}
+
+	query := fmt.Sprintf(`SELECT %s, ts_rank(c.search_vector, q) AS rank,
+	ts_headline(c.language, replace(replace(replace(c.text, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), q, '%s') AS snippet,
+	COUNT(*) OVER () AS total
+FROM %s
+WHERE c.search_vector @@ q AND c.deleted_at IS NULL AND c.status = 'published' AND %s
+ORDER BY rank DESC, c.id
+LIMIT $3 OFFSET $4`, aliasedCommentColumns("c"), headlineOptions, source, filter)
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, keyword, config, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	zlog.Logger.Info().Msg("Searching comments")
+
+	res := make([]*SearchResult, 0)
+	ids := make([]string, 0)
+	total := 0
+
+	for rows.Next() {
+		result := &SearchResult{Comment: &Comment{}, Ancestors: make([]*Comment, 0)}
+
+		err = rows.Scan(append(commentFields(result.Comment), &result.Rank, &result.Snippet, &total)...)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		res = append(res, result)
+		ids = append(ids, result.Comment.UUID.String())
+	}
+
+	if len(res) == 0 {
+		return res, 0, nil
+	}
+
+	ancestors, err := selectAncestors(db, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, result := range res {
+		result.Ancestors = append(result.Ancestors, ancestors[*result.Comment.UUID]...)
+	}
+
+	return res, total, nil
+}
+
+// selectAncestors поднимается от каждого комментария к корню ветки и возвращает родителей, начиная с корня.
+// Подъем останавливается на неопубликованном родителе, как и в дереве ответов: текст отклоненных
+// и ожидающих проверки комментариев не должен попадать в результаты поиска.
+func selectAncestors(db *dbpg.DB, ids []string) (map[uuid.UUID][]*Comment, error) {
+	query := fmt.Sprintf(`WITH RECURSIVE ancestors AS (
+	SELECT c.id AS hit_id, %s, 1 AS depth
+	FROM comment c
+	JOIN comment p ON p.id = c.parent AND p.status = 'published'
+	WHERE c.id = ANY($1::uuid[])
+	UNION ALL
+	SELECT a.hit_id, %s, a.depth + 1
+	FROM ancestors a
+	JOIN comment p ON p.id = a.parent AND p.status = 'published'
+)
+SELECT hit_id, %s FROM ancestors a
+ORDER BY hit_id, depth DESC`, aliasedCommentColumns("p"), aliasedCommentColumns("p"), aliasedCommentColumns("a"))
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	res := make(map[uuid.UUID][]*Comment)
+
+	for rows.Next() {
+		var hitID uuid.UUID
+		comment := &Comment{}
+
+		err = rows.Scan(append([]any{&hitID}, commentFields(comment)...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		res[hitID] = append(res[hitID], comment)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// NewToken возвращает случайный токен для клиента и его хеш для хранения в базе
+func NewToken() (string, string, error) {
+	buf := make([]byte, 32)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(buf)
+
+	return token, HashToken(token), nil
+}
+
+// HashToken возвращает SHA-256 токена в hex
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckEditToken возвращает статус комментария и то, подходит ли к нему токен правки.
+// Для удаленного или несуществующего комментария возвращается ErrCommentNotFound.
+func CheckEditToken(db *dbpg.DB, id uuid.UUID, token string) (string, bool, error) {
+	query := `SELECT status, COALESCE(edit_token_hash, '') FROM comment WHERE id = $1 AND deleted_at IS NULL`
+
+	row, err := db.QueryRowWithRetry(context.Background(), retryStrategy, query, id)
+	if err != nil {
+		return "", false, err
+	}
+
+	var status, hash string
+
+	err = row.Scan(&status, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, ErrCommentNotFound
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return status, tokenMatches(token, hash), nil
+}
+
+// tokenMatches сравнивает токен с сохраненным хешем за постоянное время.
+// Пустой хеш означает, что токен не выдавался, и не совпадает ни с чем.
+func tokenMatches(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
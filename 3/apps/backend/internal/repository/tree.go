@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// SelectTree загружает поддерево комментария одним рекурсивным запросом.
+// Для каждого родителя берется childLimit+1 ответ: лишний ответ не попадает в выдачу,
+// а только помечает родителя через HasMore. Путь из позиций среди соседей задает
+// стабильный порядок обхода, depth ограничивает глубину. Возвращает nil, если комментария нет.
+func SelectTree(db *dbpg.DB, id string, maxDepth, childLimit int) (*CommentNode, error) {
+	query := fmt.Sprintf(`WITH RECURSIVE tree AS (
+	SELECT %s, 0 AS depth, ARRAY[1::bigint] AS path, 1::bigint AS rn
+	FROM comment c
+	WHERE c.id = $1 AND c.status = 'published'
+	UNION ALL
+	SELECT %s, t.depth + 1, t.path || ch.rn, ch.rn
+	FROM tree t
+	CROSS JOIN LATERAL (
+		SELECT %s, ROW_NUMBER() OVER (ORDER BY c.created_at, c.id) AS rn
+		FROM comment c
+		WHERE c.parent = t.id AND c.status = 'published'
+		ORDER BY c.created_at, c.id
+		LIMIT $3 + 1
+	) ch
+	WHERE t.depth < $2 AND t.rn <= $3
+)
+SELECT %s, t.depth, t.rn,
+	EXISTS(SELECT 1 FROM comment c WHERE c.parent = t.id AND c.status = 'published') AS has_children
+FROM tree t
+ORDER BY t.path`, aliasedCommentColumns("c"), aliasedCommentColumns("ch"), aliasedCommentColumns("c"), aliasedCommentColumns("t"))
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, id, maxDepth, childLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	zlog.Logger.Info().Msg("Getting comment tree")
+
+	var root *CommentNode
+	nodes := make(map[uuid.UUID]*CommentNode)
+
+	for rows.Next() {
+		node := &CommentNode{Children: make([]*CommentNode, 0)}
+
+		var rn int
+		var hasChildren bool
+
+		err = rows.Scan(append(commentFields(&node.Comment), &node.Depth, &rn, &hasChildren)...)
+		if err != nil {
+			return nil, err
+		}
+
+		if root == nil {
+			root = node
+		} else {
+			parent, ok := nodes[*node.Parent]
+			if !ok {
+				continue
+			}
+
+			if rn > childLimit {
+				parent.HasMore = true
+				continue
+			}
+
+			parent.Children = append(parent.Children, node)
+		}
+
+		// Ответы на максимальной глубине не загружались
+		node.HasMore = hasChildren && node.Depth == maxDepth
+		nodes[*node.UUID] = node
+	}
+
+	return root, nil
+}
@@ -0,0 +1,356 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	EventReply   = "reply"
+	EventMention = "mention"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Имя по умолчанию не принадлежит конкретному человеку, поэтому уведомления для него не отправляются
+const anonymousAuthor = "anonymous"
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// CreateSubscription сохраняет подписку пользователя на уведомления
+func CreateSubscription(db *dbpg.DB, sub *Subscription) error {
+	query := `INSERT INTO webhook_subscription (id, user_name, resource_id, url, secret, access_token_hash, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, query, sub.UUID, sub.User, sub.ResourceID,
+		sub.URL, sub.Secret, sub.TokenHash, sub.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msgf("Created subscription %s for %s", sub.UUID, sub.User)
+
+	return nil
+}
+
+// SelectSubscriptions возвращает подписки, созданные с токеном, хеш которого tokenHash
+func SelectSubscriptions(db *dbpg.DB, tokenHash string) ([]*Subscription, error) {
+	query := `SELECT id, user_name, resource_id, url, secret, created_at FROM webhook_subscription
+WHERE access_token_hash = $1
+ORDER BY created_at, id`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	subs := make([]*Subscription, 0)
+
+	for rows.Next() {
+		sub := &Subscription{}
+
+		err = rows.Scan(&sub.UUID, &sub.User, &sub.ResourceID, &sub.URL, &sub.Secret, &sub.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// CheckSubscriptionToken проверяет, что подписка создана с токеном, хеш которого tokenHash.
+// Чужая подписка неотличима от несуществующей.
+func CheckSubscriptionToken(db *dbpg.DB, id uuid.UUID, tokenHash string) error {
+	var exists bool
+
+	err := db.QueryRowContext(context.Background(), `SELECT EXISTS (
+	SELECT 1 FROM webhook_subscription WHERE id = $1 AND access_token_hash = $2
+)`, id, tokenHash).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// DeleteSubscription удаляет подписку вместе с ее очередью доставки, если она создана с токеном tokenHash
+func DeleteSubscription(db *dbpg.DB, id uuid.UUID, tokenHash string) error {
+	res, err := db.ExecWithRetry(context.Background(), retryStrategy, `DELETE FROM webhook_subscription
+WHERE id = $1 AND access_token_hash = $2`, id, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// SelectDeliveries возвращает историю доставки по подписке, начиная с новых
+func SelectDeliveries(db *dbpg.DB, subscriptionID uuid.UUID, limit, offset int) ([]*Delivery, error) {
+	query := `SELECT id, subscription_id, event, payload, status, attempts, last_error, next_attempt_at, created_at, delivered_at
+FROM webhook_delivery
+WHERE subscription_id = $1
+ORDER BY created_at DESC, id
+LIMIT $2 OFFSET $3`
+
+	rows, err := db.QueryWithRetry(context.Background(), retryStrategy, query, subscriptionID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	deliveries := make([]*Delivery, 0)
+
+	for rows.Next() {
+		delivery := &Delivery{}
+
+		err = rows.Scan(&delivery.UUID, &delivery.SubscriptionID, &delivery.Event, &delivery.Payload, &delivery.Status,
+			&delivery.Attempts, &delivery.LastError, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func insertMentions(ctx context.Context, tx *sql.Tx, commentID *uuid.UUID, mentions []string) error {
+	for _, user := range mentions {
+		_, err := tx.ExecContext(ctx, `INSERT INTO comment_mention (comment_id, user_name) VALUES ($1, $2)
+ON CONFLICT DO NOTHING`, commentID, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func selectMentions(ctx context.Context, tx *sql.Tx, commentID uuid.UUID) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT user_name FROM comment_mention WHERE comment_id = $1 ORDER BY user_name`, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	mentions := make([]string, 0)
+
+	for rows.Next() {
+		var user string
+
+		err = rows.Scan(&user)
+		if err != nil {
+			return nil, err
+		}
+
+		mentions = append(mentions, user)
+	}
+
+	return mentions, rows.Err()
+}
+
+// enqueueNotifications ставит в очередь события для подписчиков: автору родителя — ответ,
+// упомянутым — упоминание. Если автор родителя упомянут в ответе, он получает одно событие reply.
+// Событие попадает в очередь в одной транзакции с публикацией комментария.
+func enqueueNotifications(ctx context.Context, tx *sql.Tx, comment *Comment, mentions []string) error {
+	events := make(map[string]string)
+	users := make([]string, 0)
+
+	addTarget := func(user, event string) {
+		if user == comment.Author || user == anonymousAuthor || events[user] != "" {
+			return
+		}
+
+		events[user] = event
+		users = append(users, user)
+	}
+
+	if comment.Parent != nil {
+		var parentAuthor string
+
+		err := tx.QueryRowContext(ctx, `SELECT author FROM comment WHERE id = $1`, comment.Parent).Scan(&parentAuthor)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if parentAuthor != "" {
+			addTarget(parentAuthor, EventReply)
+		}
+	}
+
+	for _, user := range mentions {
+		addTarget(user, EventMention)
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_name FROM webhook_subscription
+WHERE user_name = ANY($1) AND (resource_id IS NULL OR resource_id = $2)`, pq.Array(users), comment.ResourceID)
+	if err != nil {
+		return err
+	}
+
+	type target struct {
+		subscriptionID uuid.UUID
+		user           string
+	}
+
+	targets := make([]target, 0)
+
+	for rows.Next() {
+		var t target
+
+		err = rows.Scan(&t.subscriptionID, &t.user)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		targets = append(targets, t)
+	}
+
+	// Запросы в той же транзакции можно выполнять только после закрытия курсора
+	err = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		payload, err := json.Marshal(&NotificationEvent{
+			Event:      events[t.user],
+			User:       t.user,
+			CommentID:  comment.UUID,
+			ParentID:   comment.Parent,
+			ResourceID: comment.ResourceID,
+			CreatedAt:  comment.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO webhook_delivery (id, subscription_id, event, payload) VALUES ($1, $2, $3, $4)`,
+			uuid.New(), t.subscriptionID, events[t.user], string(payload))
+		if err != nil {
+			return err
+		}
+	}
+
+	zlog.Logger.Info().Msgf("Queued %d notifications for comment %s", len(targets), comment.UUID)
+
+	return nil
+}
+
+// ClaimDeliveries забирает события, время отправки которых наступило, и сразу переносит
+// следующую попытку на lease вперед. Если отправитель упадет посреди доставки, событие
+// будет отправлено повторно после истечения lease, а параллельные отправители его не возьмут.
+func ClaimDeliveries(db *dbpg.DB, limit int, lease time.Duration) ([]*Delivery, error) {
+	query := `UPDATE webhook_delivery d
+SET attempts = d.attempts + 1, next_attempt_at = NOW() + $2::float8 * INTERVAL '1 second'
+FROM webhook_subscription s
+WHERE s.id = d.subscription_id AND d.id IN (
+	SELECT id FROM webhook_delivery
+	WHERE status = 'pending' AND next_attempt_at <= NOW()
+	ORDER BY next_attempt_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING d.id, d.subscription_id, d.event, d.payload, d.attempts, s.url, s.secret`
+
+	rows, err := db.QueryContext(context.Background(), query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			zlog.Logger.Error().Err(err)
+		}
+	}()
+
+	deliveries := make([]*Delivery, 0)
+
+	for rows.Next() {
+		delivery := &Delivery{Status: DeliveryPending}
+
+		err = rows.Scan(&delivery.UUID, &delivery.SubscriptionID, &delivery.Event, &delivery.Payload,
+			&delivery.Attempts, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// MarkDelivered отмечает событие доставленным
+func MarkDelivered(db *dbpg.DB, id *uuid.UUID) error {
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, `UPDATE webhook_delivery
+SET status = 'delivered', delivered_at = NOW(), last_error = '' WHERE id = $1`, id)
+
+	return err
+}
+
+// RetryDelivery откладывает следующую попытку доставки на delay
+func RetryDelivery(db *dbpg.DB, id *uuid.UUID, lastError string, delay time.Duration) error {
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, `UPDATE webhook_delivery
+SET last_error = $1, next_attempt_at = NOW() + $2::float8 * INTERVAL '1 second' WHERE id = $3`, lastError, delay.Seconds(), id)
+
+	return err
+}
+
+// FailDelivery прекращает попытки доставки события
+func FailDelivery(db *dbpg.DB, id *uuid.UUID, lastError string) error {
+	_, err := db.ExecWithRetry(context.Background(), retryStrategy, `UPDATE webhook_delivery
+SET status = 'failed', last_error = $1 WHERE id = $2`, lastError, id)
+
+	return err
+}
@@ -0,0 +1,255 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Kost0/L3/internal/netguard"
+	"github.com/Kost0/L3/internal/repository"
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultMaxBytes = 1 << 20
+
+	maxTitleLength       = 255
+	maxDescriptionLength = 1000
+	maxURLLength         = 2048
+)
+
+// Скобки и кавычки вокруг ссылки, а также знаки препинания в ее конце к адресу не относятся
+var urlRe = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// FirstURL возвращает первую http(s) ссылку из текста или пустую строку
+func FirstURL(text string) string {
+	match := strings.TrimRight(urlRe.FindString(text), ".,;:!?")
+	if len(match) > maxURLLength {
+		return ""
+	}
+
+	return match
+}
+
+// Fetcher строит карточку для первой ссылки комментария. Исходящие соединения проходят через
+// netguard; в тестах AllowPrivate пускает к httptest-серверу на 127.0.0.1.
+type Fetcher struct {
+	Client       *http.Client
+	MaxBytes     int64
+	AllowPrivate bool
+}
+
+func NewFetcher() *Fetcher {
+	f := &Fetcher{
+		MaxBytes: defaultMaxBytes,
+	}
+
+	dialer := &net.Dialer{
+		Timeout: defaultTimeout,
+		Control: f.checkAddress,
+	}
+
+	f.Client = &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   defaultTimeout,
+			ResponseHeaderTimeout: defaultTimeout,
+		},
+	}
+
+	return f
+}
+
+// checkAddress запрещает соединения с внутренними адресами, чтобы ссылкой в комментарии
+// нельзя было заставить сервер обращаться во внутреннюю сеть
+func (f *Fetcher) checkAddress(network, address string, conn syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+
+	return netguard.CheckAddress(network, address, conn)
+}
+
+// Unfurl возвращает карточку страницы или nil, если на странице нет ни заголовка, ни описания
+func (f *Fetcher) Unfurl(ctx context.Context, rawURL string) (*repository.LinkPreview, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "L3-Comments-Unfurl/1.0")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// Тип сравнивается без параметров и без учета регистра: "Text/HTML; charset=utf-8" — тоже страница
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/html" {
+		return nil, fmt.Errorf("unexpected content type %q", contentType)
+	}
+
+	// Относительный og:image считается от адреса после редиректов
+	preview, err := Parse(io.LimitReader(resp.Body, f.MaxBytes), resp.Request.URL)
+	if err != nil || preview == nil {
+		return nil, err
+	}
+
+	preview.URL = rawURL
+
+	return preview, nil
+}
+
+// Parse собирает карточку из тегов Open Graph. Заголовок и описание без них берутся из <title>
+// и meta description, а картинка без og:image остается пустой.
+func Parse(r io.Reader, base *url.URL) (*repository.LinkPreview, error) {
+	tokenizer := html.NewTokenizer(r)
+
+	var ogTitle, ogDescription, ogImage, title, description string
+	inTitle := false
+
+	// Все теги карточки стоят до <body>, дальше документ не разбирается
+	for done := false; !done; {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "meta":
+				key, content := metaAttributes(token)
+
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "og:image":
+					// Страница может перечислять несколько картинок, основная идет первой
+					if ogImage == "" {
+						ogImage = content
+					}
+				case "description":
+					description = content
+				}
+			case "body":
+				done = true
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "title" {
+				inTitle = false
+			}
+		}
+	}
+
+	preview := &repository.LinkPreview{
+		Title:       truncate(firstNonEmpty(ogTitle, title), maxTitleLength),
+		Description: truncate(firstNonEmpty(ogDescription, description), maxDescriptionLength),
+		Image:       resolveImage(base, ogImage),
+	}
+
+	if preview.Title == "" && preview.Description == "" {
+		return nil, nil
+	}
+
+	return preview, nil
+}
+
+// resolveImage делает адрес картинки абсолютным и отбрасывает все, кроме http(s)
+func resolveImage(base *url.URL, raw string) string {
+	if raw == "" || len(raw) > maxURLLength {
+		return ""
+	}
+
+	image, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	if base != nil {
+		image = base.ResolveReference(image)
+	}
+
+	if image.Scheme != "http" && image.Scheme != "https" {
+		return ""
+	}
+
+	return image.String()
+}
+
+func metaAttributes(token html.Token) (string, string) {
+	var key, content string
+
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "property", "name":
+			key = strings.ToLower(attr.Val)
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+
+	return key, content
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+
+	return string(runes[:limit])
+}
@@ -0,0 +1,155 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Kost0/L3/internal/netguard"
+)
+
+func newTestFetcher() *Fetcher {
+	f := NewFetcher()
+	f.AllowPrivate = true
+
+	return f
+}
+
+// newPage поднимает сервер, который отдает body с указанным Content-Type по любому пути
+func newPage(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestUnfurlBuildsCard(t *testing.T) {
+	server := newPage(t, "TEXT/HTML; charset=utf-8", `<html><head>
+<title>Page title</title>
+<meta property="og:title" content="Card title">
+<meta property="og:description" content="Card description">
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:image" content="/img/second.png">
+</head><body></body></html>`)
+
+	rawURL := server.URL + "/articles/1"
+
+	preview, err := newTestFetcher().Unfurl(context.Background(), rawURL)
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+
+	if preview == nil {
+		t.Fatal("Unfurl returned no preview")
+	}
+
+	if preview.Title != "Card title" || preview.Description != "Card description" {
+		t.Errorf("preview = %+v, want the Open Graph title and description", preview)
+	}
+
+	if want := server.URL + "/img/cover.png"; preview.Image != want {
+		t.Errorf("Image = %q, want %q", preview.Image, want)
+	}
+
+	if preview.URL != rawURL {
+		t.Errorf("URL = %q, want %q", preview.URL, rawURL)
+	}
+}
+
+func TestUnfurlWithoutMetadata(t *testing.T) {
+	server := newPage(t, "text/html", `<html><head></head><body><p>Nothing here</p></body></html>`)
+
+	preview, err := newTestFetcher().Unfurl(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+
+	if preview != nil {
+		t.Fatalf("preview = %+v, want nil for a page without a title", preview)
+	}
+}
+
+func TestUnfurlStopsAtMaxBytes(t *testing.T) {
+	head := `<html><head><title>Early</title>`
+	filler := `<meta name="filler" content="` + strings.Repeat("y", 4096) + `">`
+	server := newPage(t, "text/html", head+filler+`<meta property="og:description" content="Late"></head></html>`)
+
+	f := newTestFetcher()
+	f.MaxBytes = int64(len(head) + 512)
+
+	preview, err := f.Unfurl(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+
+	if preview == nil || preview.Title != "Early" {
+		t.Fatalf("preview = %+v, want the title read before the limit", preview)
+	}
+
+	if preview.Description != "" {
+		t.Errorf("Description = %q, want nothing past MaxBytes", preview.Description)
+	}
+}
+
+func TestUnfurlRejectsNonHTML(t *testing.T) {
+	for _, contentType := range []string{"image/png", "application/xhtml+xml", "text/html-sandboxed", "not a type"} {
+		server := newPage(t, contentType, `<html><head><title>Title</title></head></html>`)
+
+		if _, err := newTestFetcher().Unfurl(context.Background(), server.URL); err == nil {
+			t.Errorf("Unfurl with Content-Type %q: want an error", contentType)
+		}
+	}
+}
+
+func TestUnfurlRejectsPrivateAddress(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher().Unfurl(context.Background(), server.URL)
+	if !errors.Is(err, netguard.ErrPrivateAddress) {
+		t.Fatalf("Unfurl %s: err = %v, want %v", server.URL, err, netguard.ErrPrivateAddress)
+	}
+
+	if requests.Load() != 0 {
+		t.Fatalf("server got %d requests, want none", requests.Load())
+	}
+}
+
+func TestUnfurlRejectsScheme(t *testing.T) {
+	if _, err := newTestFetcher().Unfurl(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Fatal("Unfurl of a file URL: want an error")
+	}
+}
+
+func TestFirstURL(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"see https://example.com/page.", "https://example.com/page"},
+		{"(http://example.com/a?b=1)", "http://example.com/a?b=1"},
+		{`<a href="https://example.com">`, "https://example.com"},
+		{"no links here", ""},
+		{"ftp://example.com", ""},
+		{"https://example.com/" + strings.Repeat("a", maxURLLength), ""},
+	}
+
+	for _, tt := range tests {
+		if got := FirstURL(tt.text); got != tt.want {
+			t.Errorf("FirstURL(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
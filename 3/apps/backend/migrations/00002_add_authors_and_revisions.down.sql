@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS comment_revision;
+DROP INDEX IF EXISTS comment_parent_created_at_idx;
+ALTER TABLE comment DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE comment DROP COLUMN IF EXISTS created_at;
+ALTER TABLE comment DROP COLUMN IF EXISTS author;
\ No newline at end of file
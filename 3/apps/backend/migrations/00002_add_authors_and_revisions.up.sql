@@ -0,0 +1,15 @@
+ALTER TABLE comment ADD COLUMN author VARCHAR(100) NOT NULL DEFAULT 'anonymous';
+ALTER TABLE comment ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE comment ADD COLUMN updated_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX comment_parent_created_at_idx ON comment (parent, created_at, id);
+
+CREATE TABLE comment_revision (
+    id UUID PRIMARY KEY,
+    comment_id UUID REFERENCES comment(id) ON DELETE CASCADE,
+    text text,
+    created_at TIMESTAMP,
+    replaced_at TIMESTAMP
+);
+
+CREATE INDEX comment_revision_comment_id_idx ON comment_revision (comment_id, replaced_at);
@@ -0,0 +1 @@
+ALTER TABLE comment ADD COLUMN deleted_at TIMESTAMP DEFAULT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS comment_resource_parent_idx;
+ALTER TABLE comment DROP COLUMN IF EXISTS resource_id;
+DROP TABLE IF EXISTS resource;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE resource (
+    id VARCHAR(100) PRIMARY KEY,
+    locked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Существующие комментарии попадают в общее обсуждение
+INSERT INTO resource (id) VALUES ('default');
+
+ALTER TABLE comment ADD COLUMN resource_id VARCHAR(100) NOT NULL DEFAULT 'default' REFERENCES resource(id);
+
+CREATE INDEX comment_resource_parent_idx ON comment (resource_id, parent, created_at);
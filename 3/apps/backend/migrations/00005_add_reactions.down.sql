@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS comment_reaction;
+ALTER TABLE comment DROP COLUMN IF EXISTS reactions;
+ALTER TABLE comment DROP COLUMN IF EXISTS dislikes;
+ALTER TABLE comment DROP COLUMN IF EXISTS likes;
\ No newline at end of file
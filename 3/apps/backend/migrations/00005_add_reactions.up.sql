@@ -0,0 +1,11 @@
+ALTER TABLE comment ADD COLUMN likes INT NOT NULL DEFAULT 0;
+ALTER TABLE comment ADD COLUMN dislikes INT NOT NULL DEFAULT 0;
+ALTER TABLE comment ADD COLUMN reactions JSONB NOT NULL DEFAULT '{}';
+
+CREATE TABLE comment_reaction (
+    comment_id UUID REFERENCES comment(id) ON DELETE CASCADE,
+    user_id VARCHAR(100),
+    type VARCHAR(32),
+    created_at TIMESTAMP,
+    PRIMARY KEY (comment_id, user_id, type)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS comment_search_vector_idx;
+ALTER TABLE comment DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE comment ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (to_tsvector('russian', text)) STORED;
+ALTER TABLE comment DROP COLUMN IF EXISTS language;
\ No newline at end of file
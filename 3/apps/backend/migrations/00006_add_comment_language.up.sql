@@ -0,0 +1,6 @@
+ALTER TABLE comment ADD COLUMN language regconfig NOT NULL DEFAULT 'russian';
+
+ALTER TABLE comment DROP COLUMN search_vector;
+ALTER TABLE comment ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (to_tsvector(language, COALESCE(text, ''))) STORED;
+
+CREATE INDEX comment_search_vector_idx ON comment USING GIN (search_vector);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS moderation_action;
+DROP INDEX IF EXISTS comment_pending_idx;
+ALTER TABLE comment DROP COLUMN IF EXISTS status;
\ No newline at end of file
@@ -0,0 +1,16 @@
+ALTER TABLE comment ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published';
+
+CREATE INDEX comment_pending_idx ON comment (created_at) WHERE status = 'pending';
+
+-- Журнал не ссылается на comment, чтобы записи оставались после окончательного удаления
+CREATE TABLE moderation_action (
+    id UUID PRIMARY KEY,
+    comment_id UUID NOT NULL,
+    action VARCHAR(20),
+    moderator VARCHAR(100),
+    reason TEXT,
+    score REAL,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX moderation_action_comment_id_idx ON moderation_action (comment_id, created_at);
@@ -0,0 +1 @@
+ALTER TABLE comment DROP COLUMN IF EXISTS text_html;
\ No newline at end of file
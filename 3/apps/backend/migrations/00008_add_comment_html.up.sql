@@ -0,0 +1,4 @@
+ALTER TABLE comment ADD COLUMN text_html TEXT NOT NULL DEFAULT '';
+
+-- Старые комментарии показываются как обычный текст
+UPDATE comment SET text_html = '<p>' || replace(replace(replace(COALESCE(text, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;') || '</p>';
@@ -0,0 +1,34 @@
+-- Пустой resource_id означает подписку на все обсуждения
+CREATE TABLE webhook_subscription (
+    id UUID PRIMARY KEY,
+    user_name VARCHAR(100) NOT NULL,
+    resource_id VARCHAR(100) DEFAULT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX webhook_subscription_user_name_idx ON webhook_subscription (user_name);
+
+CREATE TABLE comment_mention (
+    comment_id UUID REFERENCES comment(id) ON DELETE CASCADE,
+    user_name VARCHAR(100),
+    PRIMARY KEY (comment_id, user_name)
+);
+
+-- Очередь отправки: событие хранится до успешной доставки или исчерпания попыток
+CREATE TABLE webhook_delivery (
+    id UUID PRIMARY KEY,
+    subscription_id UUID NOT NULL REFERENCES webhook_subscription(id) ON DELETE CASCADE,
+    event VARCHAR(20) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_delivery_subscription_id_idx ON webhook_delivery (subscription_id, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS rate_limit_claim;
+DROP TABLE IF EXISTS rate_limit_hit;
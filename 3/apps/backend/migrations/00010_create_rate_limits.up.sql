@@ -0,0 +1,13 @@
+-- Журнал запросов для скользящего окна
+CREATE TABLE rate_limit_hit (
+    key VARCHAR(300) NOT NULL,
+    at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX rate_limit_hit_key_at_idx ON rate_limit_hit (key, at);
+
+-- Ключи, занятые до expires_at: повтор текста и ответы тому же родителю
+CREATE TABLE rate_limit_claim (
+    key VARCHAR(300) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS attachment;
+ALTER TABLE comment DROP COLUMN IF EXISTS preview;
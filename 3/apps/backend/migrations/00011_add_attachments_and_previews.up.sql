@@ -0,0 +1,15 @@
+ALTER TABLE comment ADD COLUMN preview JSONB DEFAULT NULL;
+
+CREATE TABLE attachment (
+    id UUID PRIMARY KEY,
+    comment_id UUID REFERENCES comment(id) ON DELETE CASCADE,
+    blob_key VARCHAR(255) NOT NULL,
+    file_name VARCHAR(255),
+    content_type VARCHAR(100),
+    size BIGINT,
+    width INT,
+    height INT,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX attachment_comment_id_idx ON attachment (comment_id, created_at);
@@ -0,0 +1,3 @@
+-- Хранится только SHA-256 токена, сам токен один раз отдается автору при создании.
+-- У комментариев, созданных раньше, токена нет, и их нельзя редактировать.
+ALTER TABLE comment ADD COLUMN edit_token_hash VARCHAR(64) DEFAULT NULL;
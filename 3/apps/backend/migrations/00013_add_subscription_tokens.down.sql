@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS webhook_subscription_access_token_hash_idx;
+ALTER TABLE webhook_subscription DROP COLUMN IF EXISTS access_token_hash;
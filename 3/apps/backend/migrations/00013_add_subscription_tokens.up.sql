@@ -0,0 +1,5 @@
+-- Подписками управляет тот, кто предъявит токен, выданный при создании. Хранится только SHA-256.
+-- Подписки, созданные раньше, продолжают получать уведомления, но управлять ими через API нельзя.
+ALTER TABLE webhook_subscription ADD COLUMN access_token_hash VARCHAR(64) DEFAULT NULL;
+
+CREATE INDEX webhook_subscription_access_token_hash_idx ON webhook_subscription (access_token_hash);
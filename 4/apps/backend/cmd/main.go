@@ -35,9 +35,22 @@ func main() {
 
 	topicName := "test1234"
 
-	err = startKafka.EnsureTopicExists("kafka:9092", topicName)
+	// Недоставленных задач мало, им хватает одной партиции
+	topics := map[string]int{
+		topicName:                             startKafka.PartitionsFromEnv(),
+		startKafka.DeadLetterTopic(topicName): 1,
+	}
+
+	for topic, partitions := range topics {
+		err = startKafka.EnsureTopicExists("kafka:9092", topic, partitions)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Msgf("Error creating topic %s", topic)
+		}
+	}
 
-	go startKafka.StartConsumer(ctx, db, client, topicName)
+	consumerConfig := startKafka.ConsumerConfigFromEnv()
+
+	go startKafka.StartConsumer(ctx, db, client, topicName, consumerConfig)
 
 	writer := startKafka.StartProducer(topicName)
 
@@ -47,6 +60,8 @@ func main() {
 		DB:     db,
 		Client: client,
 		Writer: writer,
+
+		MemoryLimit: consumerConfig.MemoryLimit,
 	}
 
 	engine := ginext.New("")
@@ -61,10 +76,20 @@ func main() {
 
 	engine.GET("image/:id", handler.GetPhoto)
 
+	engine.GET("image/:id/variants", handler.GetPhotoVariants)
+
+	engine.GET("image/:id/metadata", handler.GetPhotoMetadata)
+
 	engine.DELETE("image/:id", handler.DeletePhoto)
 
 	engine.GET("status/:id", handler.GetPhotoStatus)
 
+	engine.POST("watermarks", handler.UploadWatermark)
+
+	engine.GET("watermarks", handler.GetWatermarks)
+
+	engine.GET("metrics", handler.GetMetrics)
+
 	err = engine.Run(":8080")
 	if err != nil {
 		panic(err)
@@ -0,0 +1,278 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var (
+	jpegExifHeader = []byte("Exif\x00\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+	tiffLittle     = []byte("II*\x00")
+	tiffBig        = []byte("MM\x00*")
+)
+
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP1 = 0xE1
+	// APP13 — блок Photoshop с IPTC: подписи, автор, иногда место съемки
+	markerAPP13 = 0xED
+
+	maxSegmentLength = 0xFFFF
+)
+
+// Метаданные PNG: сам EXIF, текстовые поля (в том числе XMP) и время изменения
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// Read находит блок EXIF в JPEG, PNG или WebP и разбирает его. TIFF сам устроен как блок EXIF,
+// поэтому разбирается целиком. Если блока нет или формат другой, возвращается nil без ошибки.
+func Read(data []byte) (*Info, error) {
+	if isTIFF(data) {
+		return Parse(data)
+	}
+
+	raw := Extract(data)
+	if raw == nil {
+		return nil, nil
+	}
+
+	return Parse(raw)
+}
+
+// Extract возвращает блок EXIF в формате TIFF или nil. Для самого TIFF тоже возвращается nil:
+// его каталоги ссылаются на изображение, и такой блок нельзя перенести в другой файл.
+func Extract(data []byte) []byte {
+	switch {
+	case isJPEG(data):
+		var raw []byte
+
+		walkJPEG(data, func(marker byte, segment []byte) bool {
+			if marker == markerAPP1 && bytes.HasPrefix(segment[4:], jpegExifHeader) {
+				raw = segment[4+len(jpegExifHeader):]
+				return false
+			}
+
+			return true
+		})
+
+		return raw
+	case isPNG(data):
+		var raw []byte
+
+		walkPNG(data, func(typ string, chunk []byte) bool {
+			if typ == "eXIf" {
+				raw = chunk[8 : len(chunk)-4]
+				return false
+			}
+
+			return true
+		})
+
+		return raw
+	case isWebP(data):
+		var raw []byte
+
+		walkWebP(data, func(fourCC string, chunk []byte) bool {
+			if fourCC == "EXIF" {
+				// Некоторые программы записывают блок с заголовком, как в JPEG
+				raw = bytes.TrimPrefix(chunk[8:8+binary.LittleEndian.Uint32(chunk[4:8])], jpegExifHeader)
+				return false
+			}
+
+			return true
+		})
+
+		return raw
+	default:
+		return nil
+	}
+}
+
+// Strip удаляет из JPEG, PNG и WebP блоки EXIF и XMP, а из JPEG еще и IPTC.
+// Профиль ICC остается: без него меняются цвета. Данные других форматов возвращаются как есть,
+// в том числе TIFF: метаданные в нем лежат в тех же каталогах, что и изображение, и его
+// нужно перекодировать.
+func Strip(data []byte) []byte {
+	switch {
+	case isJPEG(data):
+		return stripJPEG(data)
+	case isPNG(data):
+		return stripPNG(data)
+	case isWebP(data):
+		return stripWebP(data)
+	default:
+		return data
+	}
+}
+
+// InsertJPEG добавляет блок EXIF в JPEG сразу после маркера начала изображения
+func InsertJPEG(data, raw []byte) []byte {
+	length := 2 + len(jpegExifHeader) + len(raw)
+	if !isJPEG(data) || length > maxSegmentLength {
+		return data
+	}
+
+	out := make([]byte, 0, len(data)+2+length)
+	out = append(out, data[:2]...)
+	out = append(out, 0xFF, markerAPP1, byte(length>>8), byte(length))
+	out = append(out, jpegExifHeader...)
+	out = append(out, raw...)
+
+	return append(out, data[2:]...)
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) >= 4 && data[0] == 0xFF && data[1] == markerSOI
+}
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, tiffLittle) || bytes.HasPrefix(data, tiffBig)
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// walkJPEG перебирает сегменты с длиной до начала сжатых данных. segment включает маркер и длину.
+// Обход останавливается, если fn возвращает false. Возвращается смещение, где обход закончился.
+func walkJPEG(data []byte, fn func(marker byte, segment []byte) bool) int {
+	offset := 2
+
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return offset
+		}
+
+		marker := data[offset+1]
+
+		// Байты 0xFF перед маркером допустимы как заполнитель
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+
+		if marker == markerSOS {
+			return offset
+		}
+
+		end := offset + 2 + int(binary.BigEndian.Uint16(data[offset+2:]))
+		if end > len(data) || end < offset+4 {
+			return offset
+		}
+
+		if !fn(marker, data[offset:end]) {
+			return offset
+		}
+
+		offset = end
+	}
+
+	return offset
+}
+
+func stripJPEG(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	end := walkJPEG(data, func(marker byte, segment []byte) bool {
+		if marker != markerAPP1 && marker != markerAPP13 {
+			out = append(out, segment...)
+		}
+
+		return true
+	})
+
+	return append(out, data[end:]...)
+}
+
+// walkPNG перебирает чанки PNG. chunk включает длину, тип и контрольную сумму.
+func walkPNG(data []byte, fn func(typ string, chunk []byte) bool) {
+	offset := len(pngSignature)
+
+	for offset+12 <= len(data) {
+		length := int64(binary.BigEndian.Uint32(data[offset:]))
+		end := int64(offset) + 12 + length
+		if end > int64(len(data)) {
+			return
+		}
+
+		if !fn(string(data[offset+4:offset+8]), data[offset:end]) {
+			return
+		}
+
+		offset = int(end)
+	}
+}
+
+func stripPNG(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	walkPNG(data, func(typ string, chunk []byte) bool {
+		if !pngMetadataChunks[typ] {
+			out = append(out, chunk...)
+		}
+
+		return true
+	})
+
+	return out
+}
+
+// walkWebP перебирает чанки RIFF. chunk включает FourCC, размер и выравнивающий байт.
+func walkWebP(data []byte, fn func(fourCC string, chunk []byte) bool) {
+	offset := 12
+
+	for offset+8 <= len(data) {
+		size := int64(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := int64(offset) + 8 + size + size%2
+		if end > int64(len(data)) {
+			return
+		}
+
+		if !fn(string(data[offset:offset+4]), data[offset:end]) {
+			return
+		}
+
+		offset = int(end)
+	}
+}
+
+func stripWebP(data []byte) []byte {
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	walkWebP(data, func(fourCC string, chunk []byte) bool {
+		switch fourCC {
+		case "EXIF", "XMP ":
+			return true
+		case "VP8X":
+			if len(chunk) <= 8 {
+				break
+			}
+
+			// Флаги расширенного формата объявляют наличие EXIF и XMP, их нужно снять
+			chunk = bytes.Clone(chunk)
+			chunk[8] &^= 0x08 | 0x04
+		}
+
+		out = append(out, chunk...)
+
+		return true
+	})
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+
+	return out
+}
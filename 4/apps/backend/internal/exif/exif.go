@@ -0,0 +1,232 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Теги TIFF/EXIF, которые читает сервис
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+)
+
+// Типы значений TIFF
+const (
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+const (
+	entrySize     = 12
+	maxIFDEntries = 1000
+	dateLayout    = "2006:01:02 15:04:05"
+)
+
+var ErrInvalid = errors.New("invalid exif data")
+
+// Info — данные EXIF, которые сохраняются вместе с фото
+type Info struct {
+	Orientation int
+	Make        string
+	Model       string
+	TakenAt     *time.Time
+	HasGPS      bool
+}
+
+// tiff — блок EXIF в формате TIFF: заголовок, порядок байт и каталоги (IFD) со ссылками по смещению
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalid
+	}
+
+	var order binary.ByteOrder
+
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, ErrInvalid
+	}
+
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, ErrInvalid
+	}
+
+	return &tiff{data: data, order: order}, nil
+}
+
+func (t *tiff) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+type entry struct {
+	offset int
+	tag    uint16
+	typ    uint16
+	count  uint32
+}
+
+// entries возвращает записи каталога по смещению offset
+func (t *tiff) entries(offset uint32) ([]entry, error) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return nil, ErrInvalid
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	if count > maxIFDEntries || int(offset)+2+count*entrySize > len(t.data) {
+		return nil, ErrInvalid
+	}
+
+	entries := make([]entry, 0, count)
+
+	for i := 0; i < count; i++ {
+		o := int(offset) + 2 + i*entrySize
+
+		entries = append(entries, entry{
+			offset: o,
+			tag:    t.order.Uint16(t.data[o:]),
+			typ:    t.order.Uint16(t.data[o+2:]),
+			count:  t.order.Uint32(t.data[o+4:]),
+		})
+	}
+
+	return entries, nil
+}
+
+// uint возвращает первое значение SHORT или LONG, оно всегда лежит в самой записи
+func (t *tiff) uint(e entry) (uint32, bool) {
+	switch e.typ {
+	case typeShort:
+		return uint32(t.order.Uint16(t.data[e.offset+8:])), true
+	case typeLong:
+		return t.order.Uint32(t.data[e.offset+8:]), true
+	default:
+		return 0, false
+	}
+}
+
+// string возвращает значение ASCII. До четырех байт оно лежит в записи, длиннее — по смещению.
+func (t *tiff) string(e entry) string {
+	if e.typ != typeASCII || e.count == 0 {
+		return ""
+	}
+
+	start := int64(e.offset + 8)
+	if e.count > 4 {
+		start = int64(t.order.Uint32(t.data[e.offset+8:]))
+	}
+
+	end := start + int64(e.count)
+	if end > int64(len(t.data)) {
+		return ""
+	}
+
+	value, _, _ := strings.Cut(string(t.data[start:end]), "\x00")
+
+	// Стандарт требует ASCII, но камеры пишут и Latin-1, и мусор. Такие байты не сохранить
+	// в текстовое поле Postgres, поэтому они отбрасываются.
+	return strings.TrimSpace(strings.ToValidUTF8(value, ""))
+}
+
+// Parse читает блок EXIF в формате TIFF
+func Parse(data []byte) (*Info, error) {
+	t, err := newTIFF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := t.entries(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{}
+	var dateTime string
+
+	for _, e := range entries {
+		switch e.tag {
+		case tagMake:
+			info.Make = t.string(e)
+		case tagModel:
+			info.Model = t.string(e)
+		case tagOrientation:
+			if value, ok := t.uint(e); ok && value >= 1 && value <= 8 {
+				info.Orientation = int(value)
+			}
+		case tagDateTime:
+			dateTime = t.string(e)
+		case tagGPSIFD:
+			info.HasGPS = true
+		case tagExifIFD:
+			offset, ok := t.uint(e)
+			if !ok {
+				continue
+			}
+
+			// Ошибка во вложенном каталоге не мешает использовать то, что уже прочитано
+			if original := t.dateTimeOriginal(offset); original != "" {
+				dateTime = original
+			}
+		}
+	}
+
+	if takenAt, err := time.Parse(dateLayout, dateTime); err == nil {
+		info.TakenAt = &takenAt
+	}
+
+	return info, nil
+}
+
+func (t *tiff) dateTimeOriginal(offset uint32) string {
+	entries, err := t.entries(offset)
+	if err != nil {
+		return ""
+	}
+
+	for _, e := range entries {
+		if e.tag == tagDateTimeOriginal {
+			return t.string(e)
+		}
+	}
+
+	return ""
+}
+
+// WithOrientation возвращает копию блока EXIF с другим значением ориентации.
+// После поворота пикселей старое значение повернуло бы изображение второй раз.
+func WithOrientation(data []byte, orientation int) ([]byte, error) {
+	t, err := newTIFF(bytes.Clone(data))
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := t.entries(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == typeShort {
+			t.order.PutUint16(t.data[e.offset+8:], uint16(orientation))
+		}
+	}
+
+	return t.data, nil
+}
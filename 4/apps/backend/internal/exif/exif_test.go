@@ -0,0 +1,274 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// testEntry — запись каталога для сборки блока в тестах. Для ASCII задается str, для чисел — num.
+type testEntry struct {
+	tag uint16
+	typ uint16
+	num uint32
+	str string
+}
+
+func ascii(tag uint16, value string) testEntry {
+	return testEntry{tag: tag, typ: typeASCII, str: value}
+}
+
+func short(tag uint16, value uint16) testEntry {
+	return testEntry{tag: tag, typ: typeShort, num: uint32(value)}
+}
+
+func long(tag uint16, value uint32) testEntry {
+	return testEntry{tag: tag, typ: typeLong, num: value}
+}
+
+// encodeIFD кодирует каталог, начинающийся со смещения start, и сразу за ним — длинные значения
+func encodeIFD(order binary.ByteOrder, entries []testEntry, start int) []byte {
+	ifd := make([]byte, 2+len(entries)*entrySize+4)
+	order.PutUint16(ifd, uint16(len(entries)))
+
+	var data []byte
+
+	for i, e := range entries {
+		o := 2 + i*entrySize
+
+		order.PutUint16(ifd[o:], e.tag)
+		order.PutUint16(ifd[o+2:], e.typ)
+
+		switch e.typ {
+		case typeASCII:
+			value := append([]byte(e.str), 0)
+			order.PutUint32(ifd[o+4:], uint32(len(value)))
+
+			if len(value) <= 4 {
+				copy(ifd[o+8:], value)
+				continue
+			}
+
+			order.PutUint32(ifd[o+8:], uint32(start+len(ifd)+len(data)))
+			data = append(data, value...)
+		case typeShort:
+			order.PutUint32(ifd[o+4:], 1)
+			order.PutUint16(ifd[o+8:], uint16(e.num))
+		default:
+			order.PutUint32(ifd[o+4:], 1)
+			order.PutUint32(ifd[o+8:], e.num)
+		}
+	}
+
+	return append(ifd, data...)
+}
+
+// buildTIFF собирает блок EXIF из первого каталога и, если он задан, вложенного каталога EXIF
+func buildTIFF(order binary.ByteOrder, ifd0, exifIFD []testEntry) []byte {
+	header := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(header, "II")
+	} else {
+		copy(header, "MM")
+	}
+
+	order.PutUint16(header[2:], 42)
+	order.PutUint32(header[4:], 8)
+
+	if exifIFD == nil {
+		return append(header, encodeIFD(order, ifd0, 8)...)
+	}
+
+	// Длина первого каталога не зависит от значения ссылки, поэтому ее можно узнать заранее
+	ifd0 = append(ifd0, long(tagExifIFD, 0))
+	exifStart := 8 + len(encodeIFD(order, ifd0, 8))
+	ifd0[len(ifd0)-1].num = uint32(exifStart)
+
+	data := append(header, encodeIFD(order, ifd0, 8)...)
+
+	return append(data, encodeIFD(order, exifIFD, exifStart)...)
+}
+
+func TestParse(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := buildTIFF(order, []testEntry{
+			ascii(tagMake, "Canon"),
+			ascii(tagModel, "X1"),
+			short(tagOrientation, 6),
+			ascii(tagDateTime, "2020:01:02 03:04:05"),
+			long(tagGPSIFD, 0),
+		}, []testEntry{
+			ascii(tagDateTimeOriginal, "2019:12:31 23:59:58"),
+		})
+
+		info, err := Parse(data)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", order, err)
+		}
+
+		if info.Make != "Canon" || info.Model != "X1" {
+			t.Errorf("%s: camera = %q %q, want Canon X1", order, info.Make, info.Model)
+		}
+
+		if info.Orientation != 6 {
+			t.Errorf("%s: Orientation = %d, want 6", order, info.Orientation)
+		}
+
+		if !info.HasGPS {
+			t.Errorf("%s: HasGPS = false, want true", order)
+		}
+
+		want := time.Date(2019, 12, 31, 23, 59, 58, 0, time.UTC)
+		if info.TakenAt == nil || !info.TakenAt.Equal(want) {
+			t.Errorf("%s: TakenAt = %v, want %v from DateTimeOriginal", order, info.TakenAt, want)
+		}
+	}
+}
+
+func TestParseDropsInvalidUTF8(t *testing.T) {
+	data := buildTIFF(binary.LittleEndian, []testEntry{
+		// Latin-1: "Café" и "Ñ" без перекодирования в UTF-8
+		ascii(tagMake, "Caf\xe9 Optics"),
+		ascii(tagModel, "\xd1"),
+	}, nil)
+
+	info, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if info.Make != "Caf Optics" {
+		t.Errorf("Make = %q, want %q", info.Make, "Caf Optics")
+	}
+
+	if info.Model != "" {
+		t.Errorf("Model = %q, want it empty", info.Model)
+	}
+
+	for _, value := range []string{info.Make, info.Model} {
+		if !utf8.ValidString(value) {
+			t.Errorf("%q is not valid UTF-8", value)
+		}
+	}
+}
+
+func TestParseIgnoresBadValues(t *testing.T) {
+	data := buildTIFF(binary.BigEndian, []testEntry{
+		ascii(tagMake, "Nikon Corporation"),
+		short(tagOrientation, 9),
+		ascii(tagDateTime, "not a date"),
+	}, nil)
+
+	// Смещение строки Make уводится за конец блока
+	binary.BigEndian.PutUint32(data[8+2+8:], uint32(len(data)+10))
+
+	info, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if info.Make != "" {
+		t.Errorf("Make = %q, want it empty for an out of range offset", info.Make)
+	}
+
+	if info.Orientation != 0 {
+		t.Errorf("Orientation = %d, want 0 for an unknown value", info.Orientation)
+	}
+
+	if info.TakenAt != nil {
+		t.Errorf("TakenAt = %v, want nil for a malformed date", info.TakenAt)
+	}
+}
+
+func TestParseIgnoresBrokenExifIFD(t *testing.T) {
+	data := buildTIFF(binary.LittleEndian, []testEntry{
+		ascii(tagDateTime, "2020:01:02 03:04:05"),
+		long(tagExifIFD, 1<<20),
+	}, nil)
+
+	info, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if info.TakenAt == nil || info.TakenAt.Year() != 2020 {
+		t.Errorf("TakenAt = %v, want DateTime from the first directory", info.TakenAt)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := buildTIFF(binary.LittleEndian, []testEntry{short(tagOrientation, 1)}, nil)
+
+	withIFDOffset := func(offset uint32) []byte {
+		data := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint32(data[4:], offset)
+
+		return data
+	}
+
+	withEntryCount := func(count uint16) []byte {
+		data := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint16(data[8:], count)
+
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte("II*\x00")},
+		{"unknown byte order", append([]byte("XX"), valid[2:]...)},
+		{"wrong magic", append([]byte("II\x2b\x00"), valid[4:]...)},
+		{"directory past the end", withIFDOffset(uint32(len(valid)))},
+		{"directory offset overflow", withIFDOffset(0xFFFFFFFF)},
+		{"entries past the end", withEntryCount(50)},
+		{"too many entries", withEntryCount(maxIFDEntries + 1)},
+		{"truncated", valid[:len(valid)-6]},
+	}
+
+	for _, tt := range tests {
+		info, err := Parse(tt.data)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: Parse = %+v, %v, want %v", tt.name, info, err, ErrInvalid)
+		}
+	}
+}
+
+func TestReadTIFF(t *testing.T) {
+	data := buildTIFF(binary.LittleEndian, []testEntry{short(tagOrientation, 8), long(tagGPSIFD, 0)}, nil)
+
+	info, err := Read(data)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if info == nil || info.Orientation != 8 || !info.HasGPS {
+		t.Fatalf("Read = %+v, want orientation 8 and GPS from the TIFF itself", info)
+	}
+
+	if raw := Extract(data); raw != nil {
+		t.Errorf("Extract of a TIFF file = %d bytes, want nil", len(raw))
+	}
+}
+
+func TestReadJPEG(t *testing.T) {
+	raw := buildTIFF(binary.BigEndian, []testEntry{short(tagOrientation, 3)}, nil)
+	jpeg := InsertJPEG([]byte{0xFF, markerSOI, 0xFF, markerSOS, 0, 2}, raw)
+
+	info, err := Read(jpeg)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if info == nil || info.Orientation != 3 {
+		t.Fatalf("Read = %+v, want orientation 3", info)
+	}
+
+	if info, err := Read(Strip(jpeg)); info != nil || err != nil {
+		t.Fatalf("Read after Strip = %+v, %v, want no metadata", info, err)
+	}
+}
@@ -1,10 +1,19 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 
+	"github.com/Kost0/L3/internal/exif"
 	"github.com/Kost0/L3/internal/minIO"
+	"github.com/Kost0/L3/internal/photoProcessing"
 	"github.com/Kost0/L3/internal/repository"
 	"github.com/Kost0/L3/internal/startKafka"
 	"github.com/google/uuid"
@@ -12,12 +21,17 @@ import (
 	"github.com/segmentio/kafka-go"
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
 )
 
+const maxCameraLength = 100
+
 type Handler struct {
 	DB     *dbpg.DB
 	Client *minio.Client
 	Writer *kafka.Writer
+	// MemoryLimit — сколько байт может занять изображение, декодированное при перекодировании
+	MemoryLimit int64
 }
 
 func (h *Handler) ProcessPhoto(c *ginext.Context) {
@@ -35,30 +49,92 @@ func (h *Handler) ProcessPhoto(c *ginext.Context) {
 
 	defer file.Close()
 
+	photo := &repository.Photo{
+		Status:        "в обработке",
+		ResizeTo:      c.PostForm("resize_to"),
+		WatermarkText: c.PostForm("watermark_text"),
+		GenThumbnail:  c.PostForm("get_thumbnail") == "true",
+		KeepMetadata:  c.PostForm("keep_metadata") == "true",
+	}
+
+	operations, err := parseOperations(c, photo)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	photo.Operations = operations
+
+	err = h.checkWatermarks(operations)
+	if errors.Is(err, repository.ErrWatermarkNotFound) {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	// Размеры оригинала нужны для списка вариантов, заодно отсекаются файлы, которые не декодировать
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "unsupported image: " + err.Error()})
+		return
+	}
+
+	photo.Metadata = readMetadata(data, config)
+
+	// Без явного согласия в хранилище не попадают EXIF с координатами съемки и прочие метаданные
+	if !photo.KeepMetadata {
+		data = exif.Strip(data)
+
+		// Из TIFF метаданные не вырезать, поэтому такой оригинал хранится в PNG.
+		// Ориентация уже прочитана в photo.Metadata и будет учтена при обработке.
+		if format == "tiff" {
+			data, err = photoProcessing.ReencodeTIFF(data, h.MemoryLimit)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, ginext.H{"error": "unsupported image: " + err.Error()})
+				return
+			}
+
+			format = photoProcessing.FormatPNG
+		}
+	}
+
 	imageUUID := uuid.New()
 	bucketName := "images"
-	objectKey := imageUUID.String()
-	contentType := fileHeader.Header.Get("Content-Type")
 
-	err = minIO.UploadFileFromReader(h.Client, bucketName, objectKey, file, fileHeader.Size, contentType)
+	original := &repository.Variant{
+		PhotoUUID:   &imageUUID,
+		Name:        repository.VariantOriginal,
+		Key:         repository.VariantKey(imageUUID, repository.VariantOriginal),
+		ContentType: "image/" + format,
+		Width:       config.Width,
+		Height:      config.Height,
+		Size:        int64(len(data)),
+	}
+
+	err = minIO.UploadFileFromReader(h.Client, bucketName, original.Key, bytes.NewReader(data), original.Size, original.ContentType)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
-	resizeTo := c.PostForm("resize_to")
-	watermarkText := c.PostForm("watermark_text")
-	getThumbnail := c.PostForm("get_thumbnail") == "true"
+	photo.UUID = &imageUUID
 
-	photo := &repository.Photo{
-		UUID:          &imageUUID,
-		Status:        "в обработке",
-		ResizeTo:      resizeTo,
-		WatermarkText: watermarkText,
-		GenThumbnail:  getThumbnail,
+	err = repository.InsertPhotoData(h.DB, photo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
 	}
 
-	err = repository.InsertPhotoData(h.DB, photo)
+	err = repository.UpsertVariant(h.DB, original)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
@@ -73,36 +149,262 @@ func (h *Handler) ProcessPhoto(c *ginext.Context) {
 	c.JSON(http.StatusOK, ginext.H{"Photo put in queue": imageUUID})
 }
 
+// readMetadata собирает сведения об оригинале. Поврежденный EXIF не мешает загрузке,
+// в этом случае остаются только размеры.
+func readMetadata(data []byte, config image.Config) *repository.PhotoMetadata {
+	metadata := &repository.PhotoMetadata{
+		Width:       config.Width,
+		Height:      config.Height,
+		Orientation: 1,
+	}
+
+	info, err := exif.Read(data)
+	if err != nil {
+		zlog.Logger.Warn().Msgf("Error reading exif: %v", err)
+		return metadata
+	}
+
+	if info == nil {
+		return metadata
+	}
+
+	if info.Orientation != 0 {
+		metadata.Orientation = info.Orientation
+	}
+
+	metadata.Width, metadata.Height = photoProcessing.OrientedSize(config.Width, config.Height, metadata.Orientation)
+	metadata.CameraMake = truncate(info.Make, maxCameraLength)
+	metadata.CameraModel = truncate(info.Model, maxCameraLength)
+	metadata.TakenAt = info.TakenAt
+	metadata.HasGPS = info.HasGPS
+
+	return metadata
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+
+	return string(runes[:limit])
+}
+
+// parseOperations читает шаги обработки из поля operations — JSON-массива вида
+// [{"type":"resize","width":800,"height":600},{"type":"grayscale"}].
+// Без него шаги собираются из старых полей формы. Поля format, quality и compression
+// задают формат результата и добавляются последним шагом.
+func parseOperations(c *ginext.Context, photo *repository.Photo) ([]repository.OperationSpec, error) {
+	var specs []repository.OperationSpec
+
+	if raw := c.PostForm("operations"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &specs)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+
+		specs, err = photoProcessing.LegacyOperations(photo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	output := repository.OperationSpec{
+		Type:        photoProcessing.OpFormat,
+		Format:      c.PostForm("format"),
+		Compression: c.PostForm("compression"),
+	}
+
+	if quality := c.PostForm("quality"); quality != "" {
+		var err error
+
+		output.Quality, err = strconv.Atoi(quality)
+		if err != nil {
+			return nil, errors.New("invalid quality")
+		}
+	}
+
+	if output.Format != "" || output.Quality != 0 || output.Compression != "" {
+		specs = append(specs, output)
+	}
+
+	_, err := photoProcessing.BuildPipeline(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	return specs, nil
+}
+
+// GetPhoto отдает вариант фото из параметра variant, по умолчанию — результат обработки.
+// Если клиент не принимает сохраненный формат, отдается копия в подходящем по Accept формате,
+// которая создается при первом таком запросе.
 func (h *Handler) GetPhoto(c *ginext.Context) {
-	id := c.Param("id")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid photo id"})
+		return
+	}
 
-	bucketName := "images"
-	file, err := minIO.GetPhoto(h.Client, bucketName, id)
+	name := c.DefaultQuery("variant", repository.VariantProcessed)
+
+	variant, err := repository.SelectVariant(h.DB, id, name)
+	if errors.Is(err, repository.ErrVariantNotFound) && name == repository.VariantProcessed {
+		variant, err = h.legacyVariant(id)
+	}
+	if errors.Is(err, repository.ErrVariantNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
-	objInfo, err := file.Stat()
+	c.Header("Vary", "Accept")
+
+	contentType, ok := negotiateContentType(c.GetHeader("Accept"), variant.ContentType)
+	if !ok {
+		c.JSON(http.StatusNotAcceptable, ginext.H{"error": "no acceptable image format"})
+		return
+	}
+
+	if contentType != variant.ContentType {
+		format := photoProcessing.FormatFromContentType(contentType)
+
+		variant, err = photoProcessing.Transcode(h.Client, h.DB, variant, format, h.MemoryLimit)
+		if errors.Is(err, photoProcessing.ErrImageTooLarge) {
+			c.JSON(http.StatusNotAcceptable, ginext.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
+	bucketName := "images"
+	file, err := minIO.GetPhoto(h.Client, bucketName, variant.Key)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
+	defer file.Close()
+
 	imageData, err := io.ReadAll(file)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
 	}
 
-	c.Data(http.StatusOK, objInfo.ContentType, imageData)
+	c.Data(http.StatusOK, contentType, imageData)
+}
+
+// legacyVariant описывает результат обработки фото, загруженного до разделения на варианты.
+// Записей о вариантах у таких фото нет, а результат записан поверх загруженного файла.
+func (h *Handler) legacyVariant(id uuid.UUID) (*repository.Variant, error) {
+	bucketName := "images"
+
+	info, err := minIO.StatPhoto(h.Client, bucketName, repository.LegacyKey(id))
+	if minIO.IsNotFound(err) {
+		return nil, repository.ErrVariantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &repository.Variant{
+		PhotoUUID:   &id,
+		Name:        repository.VariantProcessed,
+		Key:         info.Key,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		CreatedAt:   info.LastModified,
+	}, nil
+}
+
+// GetPhotoVariants возвращает все сохраненные варианты фото со ссылками на них
+func (h *Handler) GetPhotoVariants(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid photo id"})
+		return
+	}
+
+	variants, err := repository.SelectVariants(h.DB, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if len(variants) == 0 {
+		variant, err := h.legacyVariant(id)
+		if errors.Is(err, repository.ErrVariantNotFound) {
+			c.JSON(http.StatusNotFound, ginext.H{"error": repository.ErrPhotoNotFound.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+
+		variants = append(variants, variant)
+	}
+
+	result := make([]ginext.H, 0, len(variants))
+	for _, variant := range variants {
+		result = append(result, ginext.H{
+			"name":         variant.Name,
+			"url":          fmt.Sprintf("/image/%s?variant=%s", id, url.QueryEscape(variant.Name)),
+			"content_type": variant.ContentType,
+			"width":        variant.Width,
+			"height":       variant.Height,
+			"size":         variant.Size,
+			"created_at":   variant.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"variants": result})
 }
 
+// DeletePhoto удаляет оригинал, все варианты и записи о фото
 func (h *Handler) DeletePhoto(c *ginext.Context) {
-	id := c.Param("id")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid photo id"})
+		return
+	}
+
+	variants, err := repository.SelectVariants(h.DB, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
 
 	bucketName := "images"
-	err := minIO.RemovePhoto(h.Client, bucketName, id)
+
+	// Фото, загруженные до разделения на варианты, лежат под ключом без суффикса
+	keys := []string{repository.LegacyKey(id)}
+	for _, variant := range variants {
+		keys = append(keys, variant.Key)
+	}
+
+	for _, key := range keys {
+		err = minIO.RemovePhoto(h.Client, bucketName, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err = repository.DeletePhotoData(h.DB, id)
+	if errors.Is(err, repository.ErrPhotoNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
 		return
@@ -111,14 +413,39 @@ func (h *Handler) DeletePhoto(c *ginext.Context) {
 	c.JSON(http.StatusOK, ginext.H{"Photo deleted": id})
 }
 
+func (h *Handler) GetPhotoMetadata(c *ginext.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "invalid photo id"})
+		return
+	}
+
+	metadata, err := repository.SelectPhotoMetadata(h.DB, id)
+	if errors.Is(err, repository.ErrPhotoNotFound) {
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
 func (h *Handler) GetPhotoStatus(c *ginext.Context) {
 	id := c.Param("id")
 
-	status, err := repository.GetStatus(h.DB, id)
+	status, reason, err := repository.GetStatus(h.DB, id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, ginext.H{"status": status})
+	response := ginext.H{"status": status}
+	if reason != "" {
+		response["reason"] = reason
+	}
+
+	c.JSON(http.StatusOK, response)
 }
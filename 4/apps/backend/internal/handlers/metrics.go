@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/Kost0/L3/internal/metrics"
+	"github.com/wb-go/wbf/ginext"
+)
+
+// GetMetrics отдает метрики обработчика очереди в текстовом формате Prometheus
+func (h *Handler) GetMetrics(c *ginext.Context) {
+	var buf bytes.Buffer
+
+	err := metrics.Write(c.Request.Context(), &buf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/Kost0/L3/internal/photoProcessing"
+)
+
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiateContentType выбирает тип ответа по заголовку Accept. Сохраненный тип stored
+// предпочтительнее при равном весе, потому что отдается без перекодирования.
+// Если клиент не принимает ни один из типов, возвращается false.
+func negotiateContentType(accept, stored string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return stored, true
+	}
+
+	ranges := parseAccept(accept)
+
+	candidates := []string{stored}
+	for _, format := range photoProcessing.EncodeFormats {
+		contentType := photoProcessing.ContentType(format)
+		if contentType != stored {
+			candidates = append(candidates, contentType)
+		}
+	}
+
+	best, bestQuality := "", 0.0
+
+	for _, candidate := range candidates {
+		quality := acceptQuality(ranges, candidate)
+		if quality > bestQuality {
+			best, bestQuality = candidate, quality
+		}
+	}
+
+	return best, best != ""
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+
+	return ranges
+}
+
+// acceptQuality возвращает вес типа по самому точному подходящему диапазону:
+// image/png важнее image/*, а image/* важнее */*
+func acceptQuality(ranges []mediaRange, contentType string) float64 {
+	mainType, _, _ := strings.Cut(contentType, "/")
+
+	quality, specificity := 0.0, -1
+
+	for _, r := range ranges {
+		var s int
+
+		switch r.mediaType {
+		case contentType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s > specificity {
+			quality, specificity = r.quality, s
+		}
+	}
+
+	return quality
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/Kost0/L3/internal/minIO"
+	"github.com/Kost0/L3/internal/repository"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/ginext"
+)
+
+const (
+	maxWatermarkSize      = 2 << 20
+	maxWatermarkDimension = 4000
+)
+
+// UploadWatermark сохраняет PNG для водяных знаков. Дальше картинка используется
+// в шаге watermark по watermark_id и не загружается заново с каждым фото.
+func (h *Handler) UploadWatermark(c *ginext.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	if fileHeader.Size > maxWatermarkSize {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "watermark is too large"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxWatermarkSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+		return
+	}
+
+	// Нужен PNG: у него есть альфа-канал, и он сохраняется без потерь
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "watermark must be a png image"})
+		return
+	}
+
+	if config.Width > maxWatermarkDimension || config.Height > maxWatermarkDimension {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "watermark is too large"})
+		return
+	}
+
+	id := uuid.New()
+
+	watermark := &repository.WatermarkImage{
+		UUID:   &id,
+		Key:    repository.WatermarkKey(id),
+		Width:  config.Width,
+		Height: config.Height,
+		Size:   int64(len(data)),
+	}
+
+	bucketName := "images"
+
+	err = minIO.UploadFileFromReader(h.Client, bucketName, watermark.Key, bytes.NewReader(data), watermark.Size, "image/png")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	err = repository.InsertWatermark(h.DB, watermark)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, watermark)
+}
+
+func (h *Handler) GetWatermarks(c *ginext.Context) {
+	watermarks, err := repository.SelectWatermarks(h.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"watermarks": watermarks})
+}
+
+// checkWatermarks проверяет, что картинки, на которые ссылаются шаги, загружены
+func (h *Handler) checkWatermarks(specs []repository.OperationSpec) error {
+	for _, spec := range specs {
+		if spec.WatermarkID == "" {
+			continue
+		}
+
+		id, err := uuid.Parse(spec.WatermarkID)
+		if err != nil {
+			return err
+		}
+
+		_, err = repository.SelectWatermark(h.DB, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	ResultDone     = "done"
+	ResultFailed   = "failed"
+	ResultCanceled = "canceled"
+)
+
+// Границы гистограммы времени обработки в секундах
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// LagSource возвращает отставание по партициям на момент запроса
+type LagSource func(ctx context.Context) (map[int]int64, error)
+
+// collector хранит метрики обработчика очереди и отдает их в текстовом формате Prometheus
+type collector struct {
+	mu       sync.Mutex
+	lag      LagSource
+	busy     int
+	workers  int
+	retries  uint64
+	duration map[string]*histogram
+}
+
+var metrics = &collector{
+	duration: make(map[string]*histogram),
+}
+
+// SetWorkers запоминает размер пула обработчиков
+func SetWorkers(n int) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.workers = n
+}
+
+// SetLagSource задает, откуда брать отставание. Оно запрашивается при каждом чтении метрик,
+// а не запоминается при получении сообщений, поэтому не замирает, когда обработчики заняты.
+func SetLagSource(source LagSource) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.lag = source
+}
+
+// StartProcessing отмечает, что обработчик занят, и возвращает функцию для завершения замера
+func StartProcessing() func(result string) {
+	start := time.Now()
+
+	metrics.mu.Lock()
+	metrics.busy++
+	metrics.mu.Unlock()
+
+	return func(result string) {
+		seconds := time.Since(start).Seconds()
+
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+
+		metrics.busy--
+
+		h, ok := metrics.duration[result]
+		if !ok {
+			h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+			metrics.duration[result] = h
+		}
+
+		for i, bound := range durationBuckets {
+			if seconds <= bound {
+				h.buckets[i]++
+			}
+		}
+
+		h.sum += seconds
+		h.count++
+	}
+}
+
+// IncRetries учитывает повтор обработки после временной ошибки
+func IncRetries() {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.retries++
+}
+
+// Write выводит метрики в текстовом формате Prometheus. Если отставание узнать не удалось,
+// его метрики пропускаются, а остальные выводятся.
+func Write(ctx context.Context, w io.Writer) error {
+	lag := readLag(ctx)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	b := &writer{w: w}
+
+	if lag != nil {
+		b.printf("# HELP image_queue_lag Messages in a partition not yet committed by the consumer group.\n")
+		b.printf("# TYPE image_queue_lag gauge\n")
+
+		partitions := make([]int, 0, len(lag))
+		for partition := range lag {
+			partitions = append(partitions, partition)
+		}
+
+		sort.Ints(partitions)
+
+		var total int64
+		for _, partition := range partitions {
+			b.printf("image_queue_lag{partition=\"%d\"} %d\n", partition, lag[partition])
+			total += lag[partition]
+		}
+
+		b.printf("# HELP image_queue_lag_total Messages not yet committed in all partitions.\n")
+		b.printf("# TYPE image_queue_lag_total gauge\n")
+		b.printf("image_queue_lag_total %d\n", total)
+	}
+
+	b.printf("# HELP image_workers Size of the worker pool.\n")
+	b.printf("# TYPE image_workers gauge\n")
+	b.printf("image_workers %d\n", metrics.workers)
+
+	b.printf("# HELP image_workers_busy Workers processing an image right now.\n")
+	b.printf("# TYPE image_workers_busy gauge\n")
+	b.printf("image_workers_busy %d\n", metrics.busy)
+
+	b.printf("# HELP image_processing_retries_total Retries after transient errors.\n")
+	b.printf("# TYPE image_processing_retries_total counter\n")
+	b.printf("image_processing_retries_total %d\n", metrics.retries)
+
+	b.printf("# HELP image_processing_seconds Time from fetching a job to its final result, retries included.\n")
+	b.printf("# TYPE image_processing_seconds histogram\n")
+
+	results := make([]string, 0, len(metrics.duration))
+	for result := range metrics.duration {
+		results = append(results, result)
+	}
+
+	sort.Strings(results)
+
+	for _, result := range results {
+		h := metrics.duration[result]
+
+		for i, bound := range durationBuckets {
+			b.printf("image_processing_seconds_bucket{result=%q,le=\"%g\"} %d\n", result, bound, h.buckets[i])
+		}
+
+		b.printf("image_processing_seconds_bucket{result=%q,le=\"+Inf\"} %d\n", result, h.count)
+		b.printf("image_processing_seconds_sum{result=%q} %g\n", result, h.sum)
+		b.printf("image_processing_seconds_count{result=%q} %d\n", result, h.count)
+	}
+
+	return b.err
+}
+
+// readLag запрашивает отставание вне блокировки: запрос идет к брокеру и может быть долгим
+func readLag(ctx context.Context) map[int]int64 {
+	metrics.mu.Lock()
+	source := metrics.lag
+	metrics.mu.Unlock()
+
+	if source == nil {
+		return nil
+	}
+
+	lag, err := source(ctx)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Msg("Error reading queue lag")
+		return nil
+	}
+
+	return lag
+}
+
+// writer запоминает первую ошибку записи, чтобы не проверять каждую строку
+type writer struct {
+	w   io.Writer
+	err error
+}
+
+func (b *writer) printf(format string, args ...any) {
+	if b.err != nil {
+		return
+	}
+
+	_, b.err = fmt.Fprintf(b.w, format, args...)
+}
@@ -31,6 +31,17 @@ func GetPhoto(minioClient *minio.Client, bucketName, objectName string) (*minio.
 	return object, nil
 }
 
+func StatPhoto(minioClient *minio.Client, bucketName, objectName string) (minio.ObjectInfo, error) {
+	ctx := context.Background()
+
+	return minioClient.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+}
+
+// IsNotFound сообщает, что объекта нет в хранилище
+func IsNotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
 func RemovePhoto(minioClient *minio.Client, bucketName, objectName string) error {
 	ctx := context.Background()
 
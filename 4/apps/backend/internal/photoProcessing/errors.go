@@ -0,0 +1,43 @@
+package photoProcessing
+
+import (
+	"errors"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/Kost0/L3/internal/repository"
+)
+
+// PermanentError — ошибка, которая повторится при любой попытке: неверные шаги,
+// поврежденное, удаленное или слишком большое изображение. Такие задачи не повторяются.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent сообщает, что повтор обработки не поможет. Остальные ошибки, например
+// недоступность MinIO или базы, считаются временными.
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+
+	return errors.As(err, &permanentErr) ||
+		errors.Is(err, ErrInvalidOperation) ||
+		errors.Is(err, ErrImageTooLarge) ||
+		errors.Is(err, repository.ErrWatermarkNotFound) ||
+		minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
@@ -0,0 +1,86 @@
+package photoProcessing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+const defaultFont = "goregular"
+
+// Встроенные шрифты Go покрывают латиницу и кириллицу и не требуют файлов
+var builtinFonts = map[string][]byte{
+	"goregular": goregular.TTF,
+	"gobold":    gobold.TTF,
+	"gomono":    gomono.TTF,
+}
+
+// Имя шрифта становится частью пути, поэтому в нем нельзя использовать точки и разделители
+var fontNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	fontsMu sync.Mutex
+	fonts   = make(map[string]*opentype.Font)
+)
+
+// fontsDir — каталог с дополнительными шрифтами <name>.ttf или <name>.otf
+func fontsDir() string {
+	return os.Getenv("WATERMARK_FONTS_DIR")
+}
+
+// loadFont возвращает разобранный шрифт. Шрифты разбираются один раз и дальше берутся из памяти.
+func loadFont(name string) (*opentype.Font, error) {
+	fontsMu.Lock()
+	defer fontsMu.Unlock()
+
+	if f, ok := fonts[name]; ok {
+		return f, nil
+	}
+
+	data, err := readFont(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("font %s: %w", name, err)
+	}
+
+	fonts[name] = f
+
+	return f, nil
+}
+
+func readFont(name string) ([]byte, error) {
+	if data, ok := builtinFonts[name]; ok {
+		return data, nil
+	}
+
+	if !fontNameRe.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid font name %q", ErrInvalidOperation, name)
+	}
+
+	if dir := fontsDir(); dir != "" {
+		for _, ext := range []string{".ttf", ".otf"} {
+			data, err := os.ReadFile(filepath.Join(dir, name+ext))
+			if err == nil {
+				return data, nil
+			}
+
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unknown font %q", ErrInvalidOperation, name)
+}
@@ -0,0 +1,200 @@
+package photoProcessing
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+
+	"github.com/Kost0/L3/internal/repository"
+
+	// Декодеры регистрируются в image и доступны через image.Decode и image.DecodeConfig,
+	// TIFF регистрируется импортом выше
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+)
+
+// EncodeFormats — форматы, в которые умеет сохранять сервис, в порядке предпочтения
+var EncodeFormats = []string{FormatPNG, FormatJPEG, FormatGIF}
+
+const defaultJPEGQuality = 90
+
+var pngCompressions = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// EncodeOptions — настройки кодирования. Quality относится к JPEG, Compression — к PNG.
+type EncodeOptions struct {
+	Quality     int
+	Compression png.CompressionLevel
+}
+
+func DefaultEncodeOptions() EncodeOptions {
+	return EncodeOptions{
+		Quality:     defaultJPEGQuality,
+		Compression: png.DefaultCompression,
+	}
+}
+
+// ContentType возвращает MIME-тип формата, например image/png
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// FormatFromContentType возвращает формат по MIME-типу или пустую строку, если сохранять в него нельзя
+func FormatFromContentType(contentType string) string {
+	format, ok := strings.CutPrefix(strings.ToLower(contentType), "image/")
+	if !ok || !canEncode(format) {
+		return ""
+	}
+
+	return format
+}
+
+func canEncode(format string) bool {
+	for _, f := range EncodeFormats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// outputFormat выбирает формат результата по умолчанию. WebP, BMP и TIFF только читаются,
+// такие изображения сохраняются в PNG, чтобы не потерять качество и прозрачность.
+func outputFormat(decoded string) string {
+	if canEncode(decoded) {
+		return decoded
+	}
+
+	return FormatPNG
+}
+
+// ReencodeTIFF сохраняет TIFF в PNG без потерь и без метаданных. Ориентацию из EXIF при этом
+// нужно прочитать заранее: пиксели не поворачиваются, а тег в PNG не попадает.
+func ReencodeTIFF(data []byte, memoryLimit int64) ([]byte, error) {
+	config, err := tiff.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMemory(memoryLimit, image.Pt(config.Width, config.Height))
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := EncodeImage(img, FormatPNG, DefaultEncodeOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EncodeImage кодирует изображение в формат format
+func EncodeImage(img image.Image, format string, opts EncodeOptions) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	var err error
+
+	switch format {
+	case FormatPNG:
+		encoder := &png.Encoder{CompressionLevel: opts.Compression}
+		err = encoder.Encode(buf, img)
+	case FormatJPEG:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: opts.Quality})
+	case FormatGIF:
+		// Палитра GIF ограничена 256 цветами, диффузия ошибки скрывает полосы на градиентах
+		err = gif.Encode(buf, img, &gif.Options{NumColors: 256, Drawer: draw.FloydSteinberg})
+	default:
+		return nil, fmt.Errorf("Wrong format: %s", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Format меняет формат, в котором будет сохранен результат, и настройки кодирования.
+// Пустые поля оставляют текущие значения.
+type Format struct {
+	Format      string
+	Quality     int
+	Compression *png.CompressionLevel
+}
+
+func buildFormat(spec repository.OperationSpec) (*Format, error) {
+	op := &Format{
+		Format:  strings.ToLower(spec.Format),
+		Quality: spec.Quality,
+	}
+
+	if op.Format == "jpg" {
+		op.Format = FormatJPEG
+	}
+
+	if op.Format != "" && !canEncode(op.Format) {
+		return nil, fmt.Errorf("%w: format must be png, jpeg or gif", ErrInvalidOperation)
+	}
+
+	if op.Quality < 0 || op.Quality > 100 {
+		return nil, fmt.Errorf("%w: jpeg quality must be from 1 to 100", ErrInvalidOperation)
+	}
+
+	if spec.Compression != "" {
+		compression, ok := pngCompressions[strings.ToLower(spec.Compression)]
+		if !ok {
+			return nil, fmt.Errorf("%w: png compression must be default, none, fast or best", ErrInvalidOperation)
+		}
+
+		op.Compression = &compression
+	}
+
+	if op.Format == "" && op.Quality == 0 && op.Compression == nil {
+		return nil, fmt.Errorf("%w: format needs format, quality or compression", ErrInvalidOperation)
+	}
+
+	return op, nil
+}
+
+func (f *Format) Name() string {
+	return OpFormat
+}
+
+func (f *Format) Apply(canvas *Canvas) error {
+	if f.Format != "" {
+		canvas.Format = f.Format
+	}
+
+	if f.Quality != 0 {
+		canvas.Options.Quality = f.Quality
+	}
+
+	if f.Compression != nil {
+		canvas.Options.Compression = *f.Compression
+	}
+
+	return nil
+}
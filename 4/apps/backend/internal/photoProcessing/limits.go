@@ -0,0 +1,27 @@
+package photoProcessing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Изображение между шагами хранится как RGBA, по 4 байта на пиксель
+const bytesPerPixel = 4
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+// checkMemory проверяет, что изображение такого размера поместится в лимит памяти обработчика.
+// Нулевой лимит означает, что размер не ограничен.
+func checkMemory(limit int64, size image.Point) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	need := int64(size.X) * int64(size.Y) * bytesPerPixel
+	if need > limit {
+		return fmt.Errorf("%w: %dx%d needs %d MB, limit is %d MB", ErrImageTooLarge, size.X, size.Y, need>>20, limit>>20)
+	}
+
+	return nil
+}
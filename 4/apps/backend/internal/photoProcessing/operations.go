@@ -0,0 +1,359 @@
+package photoProcessing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/Kost0/L3/internal/repository"
+)
+
+const (
+	OpResize    = "resize"
+	OpCrop      = "crop"
+	OpRotate    = "rotate"
+	OpFlip      = "flip"
+	OpWatermark = "watermark"
+	OpBlur      = "blur"
+	OpGrayscale = "grayscale"
+	OpFormat    = "format"
+)
+
+const (
+	maxOperations = 20
+	maxDimension  = 10000
+	maxBlurRadius = 50
+
+	thumbnailSize = 300
+)
+
+var ErrInvalidOperation = errors.New("invalid operation")
+
+// Canvas — изображение между шагами обработки, формат и настройки, с которыми оно будет сохранено.
+// EXIF записывается только в JPEG и только если клиент попросил сохранить метаданные.
+// MemoryLimit ограничивает размер изображений, которые создают шаги.
+type Canvas struct {
+	Image       image.Image
+	Format      string
+	Options     EncodeOptions
+	EXIF        []byte
+	MemoryLimit int64
+}
+
+// Operation — один шаг обработки. Шаги выполняются по порядку над уже декодированным
+// изображением, кодируется оно один раз после последнего шага.
+type Operation interface {
+	Name() string
+	Apply(canvas *Canvas) error
+}
+
+// BuildPipeline проверяет шаги из запроса и превращает их в операции
+func BuildPipeline(specs []repository.OperationSpec) ([]Operation, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrInvalidOperation)
+	}
+
+	if len(specs) > maxOperations {
+		return nil, fmt.Errorf("%w: at most %d operations are allowed", ErrInvalidOperation, maxOperations)
+	}
+
+	ops := make([]Operation, 0, len(specs))
+
+	for i, spec := range specs {
+		op, err := buildOperation(spec)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+func buildOperation(spec repository.OperationSpec) (Operation, error) {
+	switch strings.ToLower(spec.Type) {
+	case OpResize:
+		return buildResize(spec)
+	case OpCrop:
+		if spec.X < 0 || spec.Y < 0 || !validDimension(spec.Width) || !validDimension(spec.Height) {
+			return nil, fmt.Errorf("%w: crop needs non-negative x, y and width and height from 1 to %d", ErrInvalidOperation, maxDimension)
+		}
+
+		return &Crop{Rect: image.Rect(spec.X, spec.Y, spec.X+spec.Width, spec.Y+spec.Height)}, nil
+	case OpRotate:
+		angle := ((spec.Angle % 360) + 360) % 360
+		if angle%90 != 0 {
+			return nil, fmt.Errorf("%w: rotate supports only multiples of 90 degrees", ErrInvalidOperation)
+		}
+
+		return &Rotate{Angle: angle}, nil
+	case OpFlip:
+		switch spec.Direction {
+		case "horizontal", "vertical":
+			return &Flip{Horizontal: spec.Direction == "horizontal"}, nil
+		default:
+			return nil, fmt.Errorf("%w: flip direction must be horizontal or vertical", ErrInvalidOperation)
+		}
+	case OpWatermark:
+		return buildWatermark(spec)
+	case OpBlur:
+		if spec.Radius <= 0 || spec.Radius > maxBlurRadius {
+			return nil, fmt.Errorf("%w: blur radius must be greater than 0 and at most %d", ErrInvalidOperation, maxBlurRadius)
+		}
+
+		return &Blur{Radius: spec.Radius}, nil
+	case OpGrayscale:
+		return &Grayscale{}, nil
+	case OpFormat:
+		return buildFormat(spec)
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidOperation, spec.Type)
+	}
+}
+
+func validDimension(value int) bool {
+	return value > 0 && value <= maxDimension
+}
+
+// LegacyOperations собирает шаги из старых полей resize_to, watermark_text и gen_thumbnail,
+// чтобы клиенты без списка операций продолжали работать. Без полей делается миниатюра.
+func LegacyOperations(photo *repository.Photo) ([]repository.OperationSpec, error) {
+	var specs []repository.OperationSpec
+
+	if photo.ResizeTo != "" {
+		width, height, err := parseSize(photo.ResizeTo)
+		if err != nil {
+			return nil, err
+		}
+
+		specs = append(specs, repository.OperationSpec{Type: OpResize, Width: width, Height: height})
+	}
+
+	if photo.WatermarkText != "" {
+		specs = append(specs, repository.OperationSpec{Type: OpWatermark, Text: photo.WatermarkText})
+	}
+
+	if photo.GenThumbnail || len(specs) == 0 {
+		specs = append(specs, repository.OperationSpec{Type: OpResize, Width: thumbnailSize, Height: thumbnailSize, Mode: ModeFill})
+	}
+
+	return specs, nil
+}
+
+// Crop вырезает прямоугольник, координаты считаются от левого верхнего угла изображения
+type Crop struct {
+	Rect image.Rectangle
+}
+
+func (cr *Crop) Name() string {
+	return OpCrop
+}
+
+func (cr *Crop) Apply(canvas *Canvas) error {
+	b := canvas.Image.Bounds()
+	rect := cr.Rect.Add(b.Min).Intersect(b)
+
+	if rect.Empty() {
+		return fmt.Errorf("crop area is outside of %dx%d image", b.Dx(), b.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+
+	draw.Draw(dst, dst.Bounds(), canvas.Image, rect.Min, draw.Src)
+
+	canvas.Image = dst
+
+	return nil
+}
+
+// Rotate поворачивает изображение по часовой стрелке на 90, 180 или 270 градусов
+type Rotate struct {
+	Angle int
+}
+
+func (r *Rotate) Name() string {
+	return OpRotate
+}
+
+func (r *Rotate) Apply(canvas *Canvas) error {
+	if r.Angle == 0 {
+		return nil
+	}
+
+	src := toRGBA(canvas.Image)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	var dst *image.RGBA
+	if r.Angle == 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+
+			switch r.Angle {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	canvas.Image = dst
+
+	return nil
+}
+
+// Flip отражает изображение по горизонтали (слева направо) или по вертикали
+type Flip struct {
+	Horizontal bool
+}
+
+func (f *Flip) Name() string {
+	return OpFlip
+}
+
+func (f *Flip) Apply(canvas *Canvas) error {
+	src := toRGBA(canvas.Image)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, h-1-y
+			if f.Horizontal {
+				dx, dy = w-1-x, y
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	canvas.Image = dst
+
+	return nil
+}
+
+// Blur размывает изображение. Три прохода прямоугольного фильтра дают результат,
+// близкий к гауссову размытию с тем же радиусом, но работают за линейное время.
+type Blur struct {
+	Radius float64
+}
+
+func (bl *Blur) Name() string {
+	return OpBlur
+}
+
+func (bl *Blur) Apply(canvas *Canvas) error {
+	radius := int(math.Round(bl.Radius))
+	if radius < 1 {
+		radius = 1
+	}
+
+	src := toRGBA(canvas.Image)
+	tmp := image.NewRGBA(src.Rect)
+
+	for i := 0; i < 3; i++ {
+		boxBlur(src, tmp, radius, true)
+		boxBlur(tmp, src, radius, false)
+	}
+
+	canvas.Image = src
+
+	return nil
+}
+
+// boxBlur усредняет каждый пиксель с соседями в пределах radius по одной оси.
+// Края продолжаются крайним пикселем, чтобы не темнели.
+func boxBlur(src, dst *image.RGBA, radius int, horizontal bool) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	lines, length := h, w
+	if !horizontal {
+		lines, length = w, h
+	}
+
+	offset := func(line, i int) int {
+		i = min(max(i, 0), length-1)
+		if horizontal {
+			return line*src.Stride + i*4
+		}
+
+		return i*src.Stride + line*4
+	}
+
+	size := 2*radius + 1
+
+	for line := 0; line < lines; line++ {
+		var sum [4]int
+
+		for i := -radius; i <= radius; i++ {
+			o := offset(line, i)
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[o+c])
+			}
+		}
+
+		for i := 0; i < length; i++ {
+			o := offset(line, i)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(sum[c] / size)
+			}
+
+			in, out := offset(line, i+radius+1), offset(line, i-radius)
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[in+c]) - int(src.Pix[out+c])
+			}
+		}
+	}
+}
+
+type Grayscale struct{}
+
+func (g *Grayscale) Name() string {
+	return OpGrayscale
+}
+
+// Apply переводит пиксели в яркость с теми же весами, что и color.GrayModel, сохраняя прозрачность
+func (g *Grayscale) Apply(canvas *Canvas) error {
+	img := toRGBA(canvas.Image)
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, gr, b := uint32(img.Pix[i]), uint32(img.Pix[i+1]), uint32(img.Pix[i+2])
+		y := uint8((19595*r + 38470*gr + 7471*b + 1<<15) >> 16)
+
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = y, y, y
+	}
+
+	canvas.Image = img
+
+	return nil
+}
+
+// toRGBA возвращает изображение как *image.RGBA с началом в нуле. Операции меняют
+// результат на месте, поэтому исходное изображение копируется, если оно другого типа.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	return dst
+}
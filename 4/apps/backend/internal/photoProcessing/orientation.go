@@ -0,0 +1,39 @@
+package photoProcessing
+
+// orientOperations возвращает шаги, которые поворачивают изображение так, как его
+// показывает камера. Значения ориентации — из спецификации EXIF, 1 означает, что
+// пиксели уже записаны в нужном положении.
+func orientOperations(orientation int) []Operation {
+	switch orientation {
+	case 2:
+		return []Operation{&Flip{Horizontal: true}}
+	case 3:
+		return []Operation{&Rotate{Angle: 180}}
+	case 4:
+		return []Operation{&Flip{Horizontal: false}}
+	case 5:
+		return []Operation{&Rotate{Angle: 90}, &Flip{Horizontal: true}}
+	case 6:
+		return []Operation{&Rotate{Angle: 90}}
+	case 7:
+		return []Operation{&Rotate{Angle: 270}, &Flip{Horizontal: true}}
+	case 8:
+		return []Operation{&Rotate{Angle: 270}}
+	default:
+		return nil
+	}
+}
+
+// swapsDimensions сообщает, меняются ли ширина и высота при выравнивании
+func swapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// OrientedSize возвращает размеры изображения после выравнивания по ориентации
+func OrientedSize(width, height, orientation int) (int, int) {
+	if swapsDimensions(orientation) {
+		return height, width
+	}
+
+	return width, height
+}
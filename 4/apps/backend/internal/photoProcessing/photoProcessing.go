@@ -2,197 +2,233 @@ package photoProcessing
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
-	"image/jpeg"
-	"image/png"
-	"strconv"
-	"strings"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 	"golang.org/x/image/draw"
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/basicfont"
-	"golang.org/x/image/math/fixed"
 
+	"github.com/Kost0/L3/internal/exif"
 	"github.com/Kost0/L3/internal/minIO"
 	"github.com/Kost0/L3/internal/repository"
 
 	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
 )
 
-func ProcessPhoto(client *minio.Client, photo *repository.Photo, db *dbpg.DB) error {
-	if photo.ResizeTo != "" {
-		parts := strings.Split(photo.ResizeTo, "x")
+// ProcessPhoto выполняет шаги обработки и отмечает фото готовым. При ошибке статус не меняется:
+// повторить обработку или отказаться от нее решает потребитель очереди. memoryLimit — сколько
+// байт может занять одно декодированное изображение, 0 снимает ограничение.
+func ProcessPhoto(client *minio.Client, photo *repository.Photo, db *dbpg.DB, memoryLimit int64) error {
+	err := runPipeline(client, db, photo, memoryLimit)
+	if err != nil {
+		return err
+	}
 
-		width, err := strconv.Atoi(parts[0])
-		if err != nil {
-			photo.Status = "failed"
-			return err
-		}
-		height, err := strconv.Atoi(parts[1])
-		if err != nil {
-			photo.Status = "failed"
-			return err
-		}
+	photo.Status = "done"
 
-		newPhoto, err := resizePhoto(client, photo, width, height)
-		if err != nil {
-			photo.Status = "failed"
-			return err
-		}
+	return repository.UpdatePhotoData(db, photo)
+}
 
-		err = repository.UpdatePhotoData(db, newPhoto)
-		if err != nil {
-			return err
-		}
-	} else if photo.WatermarkText != "" {
-		newPhoto, err := AddWatermark(client, photo)
-		if err != nil {
-			photo.Status = "failed"
-			return err
-		}
+// derivedVariants строятся из результата обработки для каждого фото
+var derivedVariants = []struct {
+	Name   string
+	Resize *Resize
+}{
+	{Name: repository.VariantThumb, Resize: &Resize{Width: thumbnailSize, Height: thumbnailSize, Mode: ModeFill, Interpolator: draw.CatmullRom}},
+	{Name: repository.VariantW800, Resize: &Resize{Width: 800, Mode: ModeFit, Interpolator: draw.CatmullRom}},
+}
 
-		err = repository.UpdatePhotoData(db, newPhoto)
-		if err != nil {
-			return err
-		}
-	} else {
-		newPhoto, err := GenThumbnail(client, photo)
-		if err != nil {
-			photo.Status = "failed"
-			return err
-		}
+// runPipeline декодирует оригинал один раз, применяет к нему шаги по порядку и сохраняет
+// результат и производные от него варианты рядом с оригиналом, не трогая его
+func runPipeline(client *minio.Client, db *dbpg.DB, photo *repository.Photo, memoryLimit int64) error {
+	specs := photo.Operations
 
-		err = repository.UpdatePhotoData(db, newPhoto)
+	// Сообщения, отправленные до появления списка операций, содержат только старые поля
+	if len(specs) == 0 {
+		var err error
+
+		specs, err = LegacyOperations(photo)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func resizePhoto(client *minio.Client, photo *repository.Photo, width, height int) (*repository.Photo, error) {
-	bucketName := "images"
-
-	src, err := minIO.GetPhoto(client, bucketName, photo.UUID.String())
+	ops, err := BuildPipeline(specs)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	img, format, err := image.Decode(src)
+	err = loadWatermarkImages(client, db, ops)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	draw.NearestNeighbor.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	bucketName := "images"
 
-	buf, err := encodeImage(dst, format)
+	key, legacy, err := originalKey(db, *photo.UUID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	contentType := fmt.Sprintf("image/%s", format)
-
-	err = minIO.UploadFileFromReader(client, bucketName, photo.UUID.String(), buf, int64(buf.Len()), contentType)
+	src, err := minIO.GetPhoto(client, bucketName, key)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	photo.Status = "done"
+	defer func() {
+		_ = src.Close()
+	}()
 
-	return photo, nil
-}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
 
-func GenThumbnail(client *minio.Client, photo *repository.Photo) (*repository.Photo, error) {
-	return resizePhoto(client, photo, 300, 300)
-}
+	// Размер берется из заголовка, чтобы не декодировать изображение, которое не поместится в память
+	config, configFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return permanent(err)
+	}
 
-func AddWatermark(client *minio.Client, photo *repository.Photo) (*repository.Photo, error) {
-	bucketName := "images"
+	// Оригинал старого фото записывается вариантом, чтобы он был доступен по API, как у новых
+	if legacy {
+		err = repository.UpsertVariant(db, &repository.Variant{
+			PhotoUUID:   photo.UUID,
+			Name:        repository.VariantOriginal,
+			Key:         key,
+			ContentType: "image/" + configFormat,
+			Width:       config.Width,
+			Height:      config.Height,
+			Size:        int64(len(data)),
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	src, err := minIO.GetPhoto(client, bucketName, photo.UUID.String())
+	err = checkMemory(memoryLimit, image.Pt(config.Width, config.Height))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	img, format, err := image.Decode(src)
+	img, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return permanent(err)
 	}
 
-	textColor := color.RGBA{R: 255, G: 255, B: 255, A: 200}
-	face := basicfont.Face7x13
+	canvas := &Canvas{
+		Image:       img,
+		Format:      outputFormat(format),
+		Options:     DefaultEncodeOptions(),
+		MemoryLimit: memoryLimit,
+	}
 
-	b := img.Bounds()
-	dst := image.NewRGBA(b)
+	// Если метаданные сохранены, они переносятся в JPEG-результаты с уже учтенной ориентацией
+	if photo.KeepMetadata {
+		if raw := exif.Extract(data); raw != nil {
+			canvas.EXIF, err = exif.WithOrientation(raw, 1)
+			if err != nil {
+				zlog.Logger.Warn().Msgf("Error copying exif of %s: %v", photo.UUID, err)
+			}
+		}
+	}
 
-	draw.Draw(dst, b, img, image.Point{}, draw.Src)
+	// Декодеры не учитывают EXIF, поэтому изображение сначала выравнивается, а уже потом
+	// к нему применяются шаги клиента с координатами в привычной ориентации
+	ops = append(orientOperations(orientation(photo, data)), ops...)
 
-	d := &font.Drawer{
-		Dst:  dst,
-		Src:  image.NewUniform(textColor),
-		Face: face,
+	for _, op := range ops {
+		err = op.Apply(canvas)
+		if err != nil {
+			return permanent(fmt.Errorf("%s: %w", op.Name(), err))
+		}
 	}
 
-	textWidth := font.MeasureString(face, photo.WatermarkText).Ceil()
-	//textHeight := face.Metrics().Height.Ceil()
-	ascent := face.Metrics().Ascent.Ceil()
+	err = storeVariant(client, db, *photo.UUID, repository.VariantProcessed, canvas)
+	if err != nil {
+		return err
+	}
 
-	x := b.Dx() - textWidth - 20
-	y := b.Dy() - 20
+	for _, derived := range derivedVariants {
+		variant := &Canvas{Image: canvas.Image, Format: canvas.Format, Options: canvas.Options, EXIF: canvas.EXIF, MemoryLimit: canvas.MemoryLimit}
 
-	if x < 0 {
-		x = 10
-	}
-	if y < ascent+10 {
-		y = ascent + 10
-	}
+		err = derived.Resize.Apply(variant)
+		if err != nil {
+			return permanent(fmt.Errorf("%s: %w", derived.Name, err))
+		}
 
-	d.Dot = fixed.Point26_6{
-		X: fixed.I(x),
-		Y: fixed.I(y),
+		err = storeVariant(client, db, *photo.UUID, derived.Name, variant)
+		if err != nil {
+			return err
+		}
 	}
 
-	d.DrawString(photo.WatermarkText)
+	return nil
+}
 
-	buf, err := encodeImage(dst, format)
+// originalKey возвращает ключ оригинала. У фото, загруженных до разделения на варианты,
+// записи об оригинале нет, и он лежит под старым ключом — тогда legacy равен true.
+func originalKey(db *dbpg.DB, id uuid.UUID) (key string, legacy bool, err error) {
+	variant, err := repository.SelectVariant(db, id, repository.VariantOriginal)
+	if errors.Is(err, repository.ErrVariantNotFound) {
+		return repository.LegacyKey(id), true, nil
+	}
 	if err != nil {
-		return nil, err
+		return "", false, err
 	}
 
-	contentType := fmt.Sprintf("image/%s", format)
+	return variant.Key, false, nil
+}
 
-	err = minIO.UploadFileFromReader(client, bucketName, photo.UUID.String(), buf, int64(buf.Len()), contentType)
-	if err != nil {
-		return nil, err
+// orientation берет ориентацию, прочитанную при загрузке. У фото, загруженных раньше,
+// ее нет в сообщении, но оригинал у них хранится вместе с EXIF.
+func orientation(photo *repository.Photo, data []byte) int {
+	if photo.Metadata != nil {
+		return photo.Metadata.Orientation
 	}
 
-	photo.Status = "done"
+	info, err := exif.Read(data)
+	if err != nil || info == nil {
+		return 1
+	}
 
-	return photo, nil
+	return info.Orientation
 }
 
-func encodeImage(img image.Image, format string) (*bytes.Buffer, error) {
-	buf := new(bytes.Buffer)
-	var err error
+// storeVariant кодирует изображение, загружает его по ключу варианта и записывает вариант в БД
+func storeVariant(client *minio.Client, db *dbpg.DB, id uuid.UUID, name string, canvas *Canvas) error {
+	bucketName := "images"
+
+	buf, err := EncodeImage(canvas.Image, canvas.Format, canvas.Options)
+	if err != nil {
+		return permanent(err)
+	}
+
+	if canvas.Format == FormatJPEG && len(canvas.EXIF) > 0 {
+		buf = bytes.NewBuffer(exif.InsertJPEG(buf.Bytes(), canvas.EXIF))
+	}
 
-	switch format {
-	case "png":
-		err = png.Encode(buf, img)
-	case "jpeg":
-		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 90})
-	default:
-		return nil, fmt.Errorf("Wrong format: %s", format)
+	variant := &repository.Variant{
+		PhotoUUID:   &id,
+		Name:        name,
+		Key:         repository.VariantKey(id, name),
+		ContentType: ContentType(canvas.Format),
+		Width:       canvas.Image.Bounds().Dx(),
+		Height:      canvas.Image.Bounds().Dy(),
+		Size:        int64(buf.Len()),
 	}
 
+	err = minIO.UploadFileFromReader(client, bucketName, variant.Key, buf, variant.Size, variant.ContentType)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return buf, nil
+	return repository.UpsertVariant(db, variant)
 }
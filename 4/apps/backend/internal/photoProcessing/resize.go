@@ -0,0 +1,245 @@
+package photoProcessing
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/Kost0/L3/internal/repository"
+)
+
+// Режимы изменения размера:
+// fit — вписать в рамку с сохранением пропорций,
+// fill — заполнить рамку целиком, обрезав лишнее по центру,
+// pad — вписать и дополнить до размеров рамки фоном,
+// exact — растянуть ровно до рамки без учета пропорций.
+const (
+	ModeFit   = "fit"
+	ModeFill  = "fill"
+	ModePad   = "pad"
+	ModeExact = "exact"
+)
+
+var interpolators = map[string]draw.Interpolator{
+	"nearest":    draw.NearestNeighbor,
+	"bilinear":   draw.ApproxBiLinear,
+	"catmullrom": draw.CatmullRom,
+}
+
+const defaultInterpolation = "catmullrom"
+
+// Resize меняет размер изображения. Одна из сторон может быть нулевой,
+// тогда она считается по пропорциям исходного изображения.
+type Resize struct {
+	Width        int
+	Height       int
+	Mode         string
+	Interpolator draw.Interpolator
+	// Upscale разрешает увеличивать изображения меньше рамки, по умолчанию они остаются как есть
+	Upscale    bool
+	Background color.Color
+}
+
+func buildResize(spec repository.OperationSpec) (*Resize, error) {
+	if spec.Width == 0 && spec.Height == 0 {
+		return nil, fmt.Errorf("%w: resize needs width, height or both", ErrInvalidOperation)
+	}
+
+	if (spec.Width != 0 && !validDimension(spec.Width)) || (spec.Height != 0 && !validDimension(spec.Height)) {
+		return nil, fmt.Errorf("%w: resize width and height must be from 1 to %d", ErrInvalidOperation, maxDimension)
+	}
+
+	mode := strings.ToLower(spec.Mode)
+	switch mode {
+	case "":
+		mode = ModeFit
+	case ModeFit, ModeFill, ModePad, ModeExact:
+	default:
+		return nil, fmt.Errorf("%w: resize mode must be fit, fill, pad or exact", ErrInvalidOperation)
+	}
+
+	interpolation := strings.ToLower(spec.Interpolation)
+	if interpolation == "" {
+		interpolation = defaultInterpolation
+	}
+
+	interpolator, ok := interpolators[interpolation]
+	if !ok {
+		return nil, fmt.Errorf("%w: interpolation must be nearest, bilinear or catmullrom", ErrInvalidOperation)
+	}
+
+	background := color.Color(color.White)
+	if spec.Background != "" {
+		var err error
+
+		background, err = parseColor(spec.Background)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Resize{
+		Width:        spec.Width,
+		Height:       spec.Height,
+		Mode:         mode,
+		Interpolator: interpolator,
+		Upscale:      spec.Upscale,
+		Background:   background,
+	}, nil
+}
+
+func (r *Resize) Name() string {
+	return OpResize
+}
+
+func (r *Resize) Apply(canvas *Canvas) error {
+	b := canvas.Image.Bounds()
+	srcW, srcH := float64(b.Dx()), float64(b.Dy())
+
+	width, height := r.Width, r.Height
+	if width == 0 {
+		width = max(round(srcW*float64(height)/srcH), 1)
+	}
+	if height == 0 {
+		height = max(round(srcH*float64(width)/srcW), 1)
+	}
+
+	scaleX, scaleY := float64(width)/srcW, float64(height)/srcH
+
+	switch r.Mode {
+	case ModeFill:
+		scaleX = max(scaleX, scaleY)
+		scaleY = scaleX
+	case ModeFit, ModePad:
+		scaleX = min(scaleX, scaleY)
+		scaleY = scaleX
+	}
+
+	if !r.Upscale {
+		scaleX, scaleY = min(scaleX, 1), min(scaleY, 1)
+	}
+
+	outW, outH := max(round(srcW*scaleX), 1), max(round(srcH*scaleY), 1)
+	src := b
+
+	// При заполнении рамки изображение выходит за нее, лишнее обрезается поровну с обеих сторон
+	if r.Mode == ModeFill {
+		outW, outH = min(outW, width), min(outH, height)
+
+		cropW := min(max(round(float64(outW)/scaleX), 1), b.Dx())
+		cropH := min(max(round(float64(outH)/scaleY), 1), b.Dy())
+
+		minX := b.Min.X + (b.Dx()-cropW)/2
+		minY := b.Min.Y + (b.Dy()-cropH)/2
+
+		src = image.Rect(minX, minY, minX+cropW, minY+cropH)
+	}
+
+	dstRect := image.Rect(0, 0, outW, outH)
+	bounds := dstRect
+
+	if r.Mode == ModePad {
+		bounds = image.Rect(0, 0, width, height)
+		dstRect = dstRect.Add(image.Pt((width-outW)/2, (height-outH)/2))
+	}
+
+	if bounds.Size() == b.Size() && dstRect == bounds && src == b {
+		return nil
+	}
+
+	err := checkMemory(canvas.MemoryLimit, bounds.Size())
+	if err != nil {
+		return err
+	}
+
+	dst := image.NewRGBA(bounds)
+	op := draw.Src
+
+	if r.Mode == ModePad {
+		draw.Draw(dst, bounds, image.NewUniform(r.Background), image.Point{}, draw.Src)
+		op = draw.Over
+	}
+
+	r.Interpolator.Scale(dst, dstRect, canvas.Image, src, op, nil)
+
+	canvas.Image = dst
+
+	return nil
+}
+
+func round(value float64) int {
+	return int(math.Round(value))
+}
+
+// parseSize разбирает размер вида 800x600, 800x, x600 или 800.
+// Пропущенная сторона возвращается нулем и считается по пропорциям.
+func parseSize(value string) (int, int, error) {
+	widthPart, heightPart, _ := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "x")
+
+	if widthPart == "" && heightPart == "" {
+		return 0, 0, fmt.Errorf("%w: size %q must look like 800x600, 800x or x600", ErrInvalidOperation, value)
+	}
+
+	width, err := parseDimension(widthPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid width in %q", ErrInvalidOperation, value)
+	}
+
+	height, err := parseDimension(heightPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid height in %q", ErrInvalidOperation, value)
+	}
+
+	return width, height, nil
+}
+
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	dimension, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if !validDimension(dimension) {
+		return 0, fmt.Errorf("must be from 1 to %d", maxDimension)
+	}
+
+	return dimension, nil
+}
+
+// parseColor разбирает цвет вида #rgb, #rrggbb или #rrggbbaa
+func parseColor(value string) (color.Color, error) {
+	hex := strings.TrimPrefix(value, "#")
+
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	if len(hex) != 8 {
+		return nil, fmt.Errorf("%w: color %q must look like #rrggbb or #rrggbbaa", ErrInvalidOperation, value)
+	}
+
+	rgba, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: color %q must look like #rrggbb or #rrggbbaa", ErrInvalidOperation, value)
+	}
+
+	return color.NRGBA{
+		R: uint8(rgba >> 24),
+		G: uint8(rgba >> 16),
+		B: uint8(rgba >> 8),
+		A: uint8(rgba),
+	}, nil
+}
@@ -0,0 +1,80 @@
+package photoProcessing
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/wb-go/wbf/dbpg"
+
+	"github.com/Kost0/L3/internal/minIO"
+	"github.com/Kost0/L3/internal/repository"
+)
+
+// TranscodedName возвращает имя варианта, перекодированного в другой формат, например "thumb.gif"
+func TranscodedName(name, format string) string {
+	return name + "." + format
+}
+
+// Transcode возвращает вариант source в формате format. Перекодированный вариант сохраняется
+// рядом с исходным, поэтому изображение декодируется один раз, а не на каждый запрос.
+// Сохраненная копия используется, пока исходный вариант не перезаписан повторной обработкой.
+func Transcode(client *minio.Client, db *dbpg.DB, source *repository.Variant, format string, memoryLimit int64) (*repository.Variant, error) {
+	name := TranscodedName(source.Name, format)
+
+	cached, err := repository.SelectVariant(db, *source.PhotoUUID, name)
+	if err == nil && !cached.CreatedAt.Before(source.CreatedAt) {
+		return cached, nil
+	}
+	if err != nil && !errors.Is(err, repository.ErrVariantNotFound) {
+		return nil, err
+	}
+
+	bucketName := "images"
+
+	src, err := minIO.GetPhoto(client, bucketName, source.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = src.Close()
+	}()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Запрос на чтение подчиняется тому же лимиту памяти, что и обработчики очереди
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMemory(memoryLimit, image.Pt(config.Width, config.Height))
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := &Canvas{
+		Image:       img,
+		Format:      format,
+		Options:     DefaultEncodeOptions(),
+		MemoryLimit: memoryLimit,
+	}
+
+	err = storeVariant(client, db, *source.PhotoUUID, name, canvas)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.SelectVariant(db, *source.PhotoUUID, name)
+}
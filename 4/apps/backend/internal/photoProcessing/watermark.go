@@ -0,0 +1,318 @@
+package photoProcessing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/Kost0/L3/internal/minIO"
+	"github.com/Kost0/L3/internal/repository"
+
+	"github.com/wb-go/wbf/dbpg"
+)
+
+const (
+	PositionTile = "tile"
+
+	defaultPosition  = "bottom-right"
+	defaultTextSize  = 0.05
+	defaultImageSize = 0.2
+	defaultOpacity   = 0.6
+
+	maxWatermarkText = 200
+	minFontSize      = 8
+	// Текст не шире этой доли изображения, иначе он обрезается краями
+	maxTextWidth = 0.9
+	// Доля меньшей стороны изображения, которая остается между знаком и краем
+	marginRatio = 0.02
+)
+
+// Точки привязки знака: доля свободного места слева и сверху
+var positions = map[string][2]float64{
+	"top-left":     {0, 0},
+	"top":          {0.5, 0},
+	"top-right":    {1, 0},
+	"left":         {0, 0.5},
+	"center":       {0.5, 0.5},
+	"right":        {1, 0.5},
+	"bottom-left":  {0, 1},
+	"bottom":       {0.5, 1},
+	"bottom-right": {1, 1},
+}
+
+// Watermark накладывает текст или заранее загруженную картинку. Size — доля меньшей стороны
+// изображения для высоты шрифта или доля ширины изображения для картинки.
+type Watermark struct {
+	Text     string
+	Font     string
+	ImageID  *uuid.UUID
+	Image    image.Image
+	Size     float64
+	Color    color.Color
+	Opacity  float64
+	Position string
+}
+
+func buildWatermark(spec repository.OperationSpec) (*Watermark, error) {
+	wm := &Watermark{
+		Text:     spec.Text,
+		Font:     spec.Font,
+		Size:     spec.Size,
+		Color:    color.White,
+		Opacity:  spec.Opacity,
+		Position: strings.ToLower(spec.Position),
+	}
+
+	if (wm.Text == "") == (spec.WatermarkID == "") {
+		return nil, fmt.Errorf("%w: watermark needs either text or watermark_id", ErrInvalidOperation)
+	}
+
+	if spec.WatermarkID != "" {
+		id, err := uuid.Parse(spec.WatermarkID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid watermark_id", ErrInvalidOperation)
+		}
+
+		wm.ImageID = &id
+
+		if wm.Size == 0 {
+			wm.Size = defaultImageSize
+		}
+	} else {
+		if len([]rune(wm.Text)) > maxWatermarkText {
+			return nil, fmt.Errorf("%w: watermark text must be from 1 to %d characters", ErrInvalidOperation, maxWatermarkText)
+		}
+
+		if wm.Font == "" {
+			wm.Font = defaultFont
+		}
+
+		_, err := loadFont(wm.Font)
+		if err != nil {
+			return nil, err
+		}
+
+		if wm.Size == 0 {
+			wm.Size = defaultTextSize
+		}
+	}
+
+	if wm.Size < 0 || wm.Size > 1 {
+		return nil, fmt.Errorf("%w: watermark size must be a fraction of the image from 0 to 1", ErrInvalidOperation)
+	}
+
+	if spec.Color != "" {
+		var err error
+
+		wm.Color, err = parseColor(spec.Color)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if wm.Opacity == 0 {
+		wm.Opacity = defaultOpacity
+	}
+
+	if wm.Opacity < 0 || wm.Opacity > 1 {
+		return nil, fmt.Errorf("%w: watermark opacity must be from 0 to 1", ErrInvalidOperation)
+	}
+
+	if wm.Position == "" {
+		wm.Position = defaultPosition
+	}
+
+	if _, ok := positions[wm.Position]; !ok && wm.Position != PositionTile {
+		return nil, fmt.Errorf("%w: unknown watermark position %q", ErrInvalidOperation, spec.Position)
+	}
+
+	return wm, nil
+}
+
+func (wm *Watermark) Name() string {
+	return OpWatermark
+}
+
+func (wm *Watermark) Apply(canvas *Canvas) error {
+	dst := toRGBA(canvas.Image)
+	b := dst.Bounds()
+
+	var mark image.Image
+	var err error
+
+	if wm.ImageID != nil {
+		mark, err = wm.imageMark(b)
+	} else {
+		mark, err = wm.textMark(b)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Прозрачность применяется ко всему знаку поверх его собственного альфа-канала
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(wm.Opacity * 255))})
+	markBounds := mark.Bounds()
+
+	for _, pt := range wm.placements(b.Size(), markBounds.Size()) {
+		r := image.Rectangle{Min: pt, Max: pt.Add(markBounds.Size())}
+		draw.DrawMask(dst, r, mark, markBounds.Min, mask, image.Point{}, draw.Over)
+	}
+
+	canvas.Image = dst
+
+	return nil
+}
+
+// textMark рисует текст на прозрачном фоне. Слишком длинный текст уменьшается до ширины изображения.
+func (wm *Watermark) textMark(b image.Rectangle) (image.Image, error) {
+	f, err := loadFont(wm.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	size := max(wm.Size*float64(min(b.Dx(), b.Dy())), minFontSize)
+
+	face, err := newFace(f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	width := font.MeasureString(face, wm.Text).Ceil()
+
+	if limit := maxTextWidth * float64(b.Dx()); float64(width) > limit && size > minFontSize {
+		_ = face.Close()
+
+		size = max(size*limit/float64(width), minFontSize)
+
+		face, err = newFace(f, size)
+		if err != nil {
+			return nil, err
+		}
+
+		width = font.MeasureString(face, wm.Text).Ceil()
+	}
+
+	defer func() {
+		_ = face.Close()
+	}()
+
+	metrics := face.Metrics()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+
+	mark := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+
+	d := &font.Drawer{
+		Dst:  mark,
+		Src:  image.NewUniform(wm.Color),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+
+	d.DrawString(wm.Text)
+
+	return mark, nil
+}
+
+func newFace(f *opentype.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// imageMark масштабирует картинку до доли ширины изображения с сохранением пропорций
+func (wm *Watermark) imageMark(b image.Rectangle) (image.Image, error) {
+	if wm.Image == nil {
+		return nil, errors.New("watermark image is not loaded")
+	}
+
+	src := wm.Image.Bounds()
+	scale := wm.Size * float64(b.Dx()) / float64(src.Dx())
+	scale = min(scale, float64(b.Dy())/float64(src.Dy()))
+
+	width := max(round(float64(src.Dx())*scale), 1)
+	height := max(round(float64(src.Dy())*scale), 1)
+
+	mark := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	draw.CatmullRom.Scale(mark, mark.Bounds(), wm.Image, src, draw.Src, nil)
+
+	return mark, nil
+}
+
+// placements возвращает левые верхние углы, в которые кладется знак
+func (wm *Watermark) placements(img, mark image.Point) []image.Point {
+	margin := max(round(marginRatio*float64(min(img.X, img.Y))), 1)
+
+	if wm.Position != PositionTile {
+		anchor := positions[wm.Position]
+
+		x := margin + round(float64(img.X-mark.X-2*margin)*anchor[0])
+		y := margin + round(float64(img.Y-mark.Y-2*margin)*anchor[1])
+
+		return []image.Point{{X: x, Y: y}}
+	}
+
+	// По горизонтали между повторами остается половина знака, по вертикали — целый знак,
+	// но шаг не меньше десятой части изображения, чтобы крошечный знак не превращался
+	// в сотни тысяч отрисовок
+	minStep := min(img.X, img.Y) / 10
+	stepX := max(mark.X+mark.X/2, minStep, 1)
+	stepY := max(mark.Y+mark.Y, minStep, 1)
+
+	var points []image.Point
+
+	for row, y := 0, margin; y < img.Y; row, y = row+1, y+stepY {
+		// Каждый второй ряд сдвинут на полшага, чтобы повторы не складывались в столбцы
+		x := margin - (row%2)*stepX/2
+
+		for ; x < img.X; x += stepX {
+			points = append(points, image.Point{X: x, Y: y})
+		}
+	}
+
+	return points
+}
+
+// loadWatermarkImages загружает картинки водяных знаков, на которые ссылаются шаги
+func loadWatermarkImages(client *minio.Client, db *dbpg.DB, ops []Operation) error {
+	bucketName := "images"
+
+	for _, op := range ops {
+		wm, ok := op.(*Watermark)
+		if !ok || wm.ImageID == nil {
+			continue
+		}
+
+		record, err := repository.SelectWatermark(db, *wm.ImageID)
+		if err != nil {
+			return err
+		}
+
+		src, err := minIO.GetPhoto(client, bucketName, record.Key)
+		if err != nil {
+			return err
+		}
+
+		wm.Image, _, err = image.Decode(src)
+		_ = src.Close()
+
+		if err != nil {
+			return permanent(fmt.Errorf("watermark %s: %w", wm.ImageID, err))
+		}
+	}
+
+	return nil
+}
@@ -1,13 +1,78 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type Photo struct {
-	UUID          *uuid.UUID `json:"uuid"`
-	Status        string     `json:"status"`
-	ResizeTo      string     `json:"resize_to"`
-	WatermarkText string     `json:"watermark_text"`
-	GenThumbnail  bool       `json:"gen_thumbnail"`
+	UUID          *uuid.UUID      `json:"uuid"`
+	Status        string          `json:"status"`
+	ResizeTo      string          `json:"resize_to"`
+	WatermarkText string          `json:"watermark_text"`
+	GenThumbnail  bool            `json:"gen_thumbnail"`
+	Operations    []OperationSpec `json:"operations"`
+	KeepMetadata  bool            `json:"keep_metadata"`
+	Metadata      *PhotoMetadata  `json:"metadata,omitempty"`
+}
+
+// PhotoMetadata — сведения об оригинале из EXIF. Размеры указаны с учетом ориентации.
+type PhotoMetadata struct {
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	Orientation int        `json:"orientation"`
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`
+	HasGPS      bool       `json:"has_gps"`
+}
+
+// OperationSpec — шаг обработки в том виде, в котором его передает клиент.
+// Какие поля нужны, зависит от Type.
+type OperationSpec struct {
+	Type          string  `json:"type"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	X             int     `json:"x,omitempty"`
+	Y             int     `json:"y,omitempty"`
+	Mode          string  `json:"mode,omitempty"`
+	Interpolation string  `json:"interpolation,omitempty"`
+	Upscale       bool    `json:"upscale,omitempty"`
+	Background    string  `json:"background,omitempty"`
+	Angle         int     `json:"angle,omitempty"`
+	Direction     string  `json:"direction,omitempty"`
+	Text          string  `json:"text,omitempty"`
+	WatermarkID   string  `json:"watermark_id,omitempty"`
+	Font          string  `json:"font,omitempty"`
+	Size          float64 `json:"size,omitempty"`
+	Color         string  `json:"color,omitempty"`
+	Opacity       float64 `json:"opacity,omitempty"`
+	Position      string  `json:"position,omitempty"`
+	Radius        float64 `json:"radius,omitempty"`
+	Format        string  `json:"format,omitempty"`
+	Quality       int     `json:"quality,omitempty"`
+	Compression   string  `json:"compression,omitempty"`
+}
+
+// Variant — объект в хранилище, относящийся к фото: оригинал или производное изображение
+type Variant struct {
+	PhotoUUID   *uuid.UUID `json:"photo_uuid"`
+	Name        string     `json:"name"`
+	Key         string     `json:"key"`
+	ContentType string     `json:"content_type"`
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	Size        int64      `json:"size"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// WatermarkImage — картинка для водяного знака, загруженная заранее и используемая по UUID
+type WatermarkImage struct {
+	UUID      *uuid.UUID `json:"uuid"`
+	Key       string     `json:"-"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Size      int64      `json:"size"`
+	CreatedAt time.Time  `json:"created_at"`
 }
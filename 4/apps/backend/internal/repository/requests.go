@@ -2,14 +2,20 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"math"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
 )
 
+const maxFailureReason = 1000
+
 var retryStrategy = retry.Strategy{
 	Attempts: 3,
 	Delay:    time.Second,
@@ -17,11 +23,25 @@ var retryStrategy = retry.Strategy{
 }
 
 func InsertPhotoData(db *dbpg.DB, photo *Photo) error {
-	query := `INSERT INTO photos(uuid, status) VALUES ($1, $2)`
+	query := `INSERT INTO photos(uuid, status, operations, keep_metadata,
+				width, height, orientation, camera_make, camera_model, taken_at, has_gps)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, $11)`
+
+	operations, err := json.Marshal(photo.Operations)
+	if err != nil {
+		return err
+	}
+
+	metadata := photo.Metadata
+	if metadata == nil {
+		metadata = &PhotoMetadata{Orientation: 1}
+	}
 
 	ctx := context.Background()
 
-	_, err := db.ExecWithRetry(ctx, retryStrategy, query, photo.UUID, photo.Status)
+	_, err = db.ExecWithRetry(ctx, retryStrategy, query, photo.UUID, photo.Status, string(operations), photo.KeepMetadata,
+		metadata.Width, metadata.Height, metadata.Orientation, metadata.CameraMake, metadata.CameraModel,
+		metadata.TakenAt, metadata.HasGPS)
 	if err != nil {
 		return err
 	}
@@ -32,7 +52,7 @@ func InsertPhotoData(db *dbpg.DB, photo *Photo) error {
 }
 
 func UpdatePhotoData(db *dbpg.DB, photo *Photo) error {
-	query := `UPDATE photos SET status = $1 WHERE uuid = $2`
+	query := `UPDATE photos SET status = $1, failure_reason = NULL WHERE uuid = $2`
 
 	ctx := context.Background()
 
@@ -46,35 +66,83 @@ func UpdatePhotoData(db *dbpg.DB, photo *Photo) error {
 	return nil
 }
 
-func GetStatus(db *dbpg.DB, id string) (string, error) {
-	query := `SELECT status FROM photos WHERE uuid = $1`
+// FailPhoto отмечает, что фото не удалось обработать, и сохраняет причину
+func FailPhoto(db *dbpg.DB, id *uuid.UUID, reason string) error {
+	query := `UPDATE photos SET status = 'failed', failure_reason = $1 WHERE uuid = $2`
+
+	if runes := []rune(reason); len(runes) > maxFailureReason {
+		reason = string(runes[:maxFailureReason])
+	}
+
+	ctx := context.Background()
+
+	_, err := db.ExecWithRetry(ctx, retryStrategy, query, reason, id)
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msg("Photo marked as failed in db")
+
+	return nil
+}
+
+// GetStatus возвращает статус фото и причину ошибки, если обработка не удалась
+func GetStatus(db *dbpg.DB, id string) (string, string, error) {
+	query := `SELECT status, COALESCE(failure_reason, '') FROM photos WHERE uuid = $1`
 
 	ctx := context.Background()
 
 	row, err := db.QueryWithRetry(ctx, retryStrategy, query, id)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	defer func() {
 		err = row.Close()
 		if err != nil {
-			zlog.Logger.Error().Err(err)
+			zlog.Logger.Error().Err(err).Msg("Error closing rows")
 		}
 	}()
 
 	status := ""
+	reason := ""
 
 	for row.Next() {
 		zlog.Logger.Info().Msg("First row")
 
-		err = row.Scan(&status)
+		err = row.Scan(&status, &reason)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 	}
 
 	zlog.Logger.Info().Msg(status)
 
-	return status, nil
+	return status, reason, nil
+}
+
+func SelectPhotoMetadata(db *dbpg.DB, id uuid.UUID) (*PhotoMetadata, error) {
+	query := `SELECT COALESCE(width, 0), COALESCE(height, 0), orientation,
+				COALESCE(camera_make, ''), COALESCE(camera_model, ''), taken_at, has_gps
+			FROM photos WHERE uuid = $1`
+
+	ctx := context.Background()
+
+	row, err := db.QueryRowWithRetry(ctx, retryStrategy, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &PhotoMetadata{}
+
+	err = row.Scan(&metadata.Width, &metadata.Height, &metadata.Orientation,
+		&metadata.CameraMake, &metadata.CameraModel, &metadata.TakenAt, &metadata.HasGPS)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPhotoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
 }
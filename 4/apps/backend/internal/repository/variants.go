@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	VariantOriginal  = "original"
+	VariantProcessed = "processed"
+	VariantThumb     = "thumb"
+	VariantW800      = "w800"
+)
+
+var (
+	ErrPhotoNotFound   = errors.New("photo not found")
+	ErrVariantNotFound = errors.New("variant not found")
+)
+
+// VariantKey возвращает ключ объекта варианта в хранилище, например "<id>/thumb"
+func VariantKey(id uuid.UUID, name string) string {
+	return id.String() + "/" + name
+}
+
+// LegacyKey возвращает ключ фото, загруженного до разделения на варианты. До обработки
+// под ним лежит загруженный файл, после — результат, записанный поверх него.
+func LegacyKey(id uuid.UUID) string {
+	return id.String()
+}
+
+// UpsertVariant записывает вариант. При повторной обработке запись заменяется,
+// как и сам объект в хранилище.
+func UpsertVariant(db *dbpg.DB, variant *Variant) error {
+	query := `INSERT INTO photo_variants(photo_uuid, name, object_key, content_type, width, height, size)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (photo_uuid, name) DO UPDATE SET
+				object_key = EXCLUDED.object_key,
+				content_type = EXCLUDED.content_type,
+				width = EXCLUDED.width,
+				height = EXCLUDED.height,
+				size = EXCLUDED.size,
+				created_at = now()`
+
+	ctx := context.Background()
+
+	_, err := db.ExecWithRetry(ctx, retryStrategy, query, variant.PhotoUUID, variant.Name, variant.Key,
+		variant.ContentType, variant.Width, variant.Height, variant.Size)
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msgf("Variant %s of %s saved", variant.Name, variant.PhotoUUID)
+
+	return nil
+}
+
+func SelectVariants(db *dbpg.DB, id uuid.UUID) ([]*Variant, error) {
+	query := `SELECT photo_uuid, name, object_key, content_type, width, height, size, created_at
+			FROM photo_variants WHERE photo_uuid = $1 ORDER BY created_at, name`
+
+	ctx := context.Background()
+
+	rows, err := db.QueryWithRetry(ctx, retryStrategy, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	variants := make([]*Variant, 0)
+
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return variants, rows.Err()
+}
+
+func SelectVariant(db *dbpg.DB, id uuid.UUID, name string) (*Variant, error) {
+	query := `SELECT photo_uuid, name, object_key, content_type, width, height, size, created_at
+			FROM photo_variants WHERE photo_uuid = $1 AND name = $2`
+
+	ctx := context.Background()
+
+	row, err := db.QueryRowWithRetry(ctx, retryStrategy, query, id, name)
+	if err != nil {
+		return nil, err
+	}
+
+	variant, err := scanVariant(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVariantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+// DeletePhotoData удаляет фото вместе с записями о вариантах
+func DeletePhotoData(db *dbpg.DB, id uuid.UUID) error {
+	query := `DELETE FROM photos WHERE uuid = $1`
+
+	ctx := context.Background()
+
+	res, err := db.ExecWithRetry(ctx, retryStrategy, query, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrPhotoNotFound
+	}
+
+	zlog.Logger.Info().Msg("Photo deleted from db")
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVariant(row rowScanner) (*Variant, error) {
+	variant := &Variant{}
+
+	err := row.Scan(&variant.PhotoUUID, &variant.Name, &variant.Key, &variant.ContentType,
+		&variant.Width, &variant.Height, &variant.Size, &variant.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/zlog"
+)
+
+var ErrWatermarkNotFound = errors.New("watermark not found")
+
+// WatermarkKey возвращает ключ картинки водяного знака в хранилище
+func WatermarkKey(id uuid.UUID) string {
+	return "watermarks/" + id.String()
+}
+
+func InsertWatermark(db *dbpg.DB, watermark *WatermarkImage) error {
+	query := `INSERT INTO watermarks(uuid, object_key, width, height, size) VALUES ($1, $2, $3, $4, $5)
+			RETURNING created_at`
+
+	ctx := context.Background()
+
+	row, err := db.QueryRowWithRetry(ctx, retryStrategy, query, watermark.UUID, watermark.Key,
+		watermark.Width, watermark.Height, watermark.Size)
+	if err != nil {
+		return err
+	}
+
+	err = row.Scan(&watermark.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	zlog.Logger.Info().Msg("Watermark inserted in db")
+
+	return nil
+}
+
+func SelectWatermark(db *dbpg.DB, id uuid.UUID) (*WatermarkImage, error) {
+	query := `SELECT uuid, object_key, width, height, size, created_at FROM watermarks WHERE uuid = $1`
+
+	ctx := context.Background()
+
+	row, err := db.QueryRowWithRetry(ctx, retryStrategy, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	watermark, err := scanWatermark(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWatermarkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return watermark, nil
+}
+
+func SelectWatermarks(db *dbpg.DB) ([]*WatermarkImage, error) {
+	query := `SELECT uuid, object_key, width, height, size, created_at FROM watermarks ORDER BY created_at DESC`
+
+	ctx := context.Background()
+
+	rows, err := db.QueryWithRetry(ctx, retryStrategy, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	watermarks := make([]*WatermarkImage, 0)
+
+	for rows.Next() {
+		watermark, err := scanWatermark(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		watermarks = append(watermarks, watermark)
+	}
+
+	return watermarks, rows.Err()
+}
+
+func scanWatermark(row rowScanner) (*WatermarkImage, error) {
+	watermark := &WatermarkImage{}
+
+	err := row.Scan(&watermark.UUID, &watermark.Key, &watermark.Width, &watermark.Height,
+		&watermark.Size, &watermark.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return watermark, nil
+}
@@ -0,0 +1,49 @@
+package startKafka
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	defaultWorkers       = 4
+	defaultPartitions    = 8
+	defaultMemoryLimitMB = 256
+)
+
+// ConsumerConfig — размер пула обработчиков и лимит памяти на одно изображение у каждого из них
+type ConsumerConfig struct {
+	Workers     int
+	MemoryLimit int64
+}
+
+// ConsumerConfigFromEnv читает KAFKA_WORKERS и WORKER_MEMORY_LIMIT_MB
+func ConsumerConfigFromEnv() ConsumerConfig {
+	return ConsumerConfig{
+		Workers:     envInt("KAFKA_WORKERS", defaultWorkers),
+		MemoryLimit: int64(envInt("WORKER_MEMORY_LIMIT_MB", defaultMemoryLimitMB)) << 20,
+	}
+}
+
+// PartitionsFromEnv читает KAFKA_PARTITIONS. Обработчиков, которым достанется работа,
+// не больше, чем партиций, поэтому партиций стоит заводить не меньше, чем обработчиков.
+func PartitionsFromEnv() int {
+	return envInt("KAFKA_PARTITIONS", defaultPartitions)
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		zlog.Logger.Warn().Msgf("Invalid %s=%q, using %d", name, value, fallback)
+		return fallback
+	}
+
+	return n
+}
@@ -3,9 +3,11 @@ package startKafka
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"sync"
 	"time"
 
+	"github.com/Kost0/L3/internal/metrics"
 	"github.com/Kost0/L3/internal/photoProcessing"
 	"github.com/Kost0/L3/internal/repository"
 	"github.com/minio/minio-go/v7"
@@ -14,10 +16,55 @@ import (
 	"github.com/wb-go/wbf/zlog"
 )
 
-func StartConsumer(ctx context.Context, db *dbpg.DB, client *minio.Client, topicName string) {
-	brokerAddress := "kafka:9092"
-	groupID := "myOrdersGroup-123456"
+const (
+	brokerAddress = "kafka:9092"
+	groupID       = "myOrdersGroup-123456"
+)
+
+// Повторы временных ошибок: 2с, 4с, 8с и так далее, но не дольше 30с между попытками
+const (
+	maxAttempts  = 5
+	retryDelay   = 2 * time.Second
+	maxRetryWait = 30 * time.Second
+)
+
+// StartConsumer запускает пул обработчиков и ждет, пока они остановятся. Каждый обработчик
+// читает задачи своим читателем в общей группе, поэтому Kafka делит между ними партиции,
+// а порядок подтверждений внутри партиции сохраняется.
+func StartConsumer(ctx context.Context, db *dbpg.DB, client *minio.Client, topicName string, config ConsumerConfig) {
+	deadLetters := StartProducer(DeadLetterTopic(topicName))
+	defer func() {
+		if err := deadLetters.Close(); err != nil {
+			zlog.Logger.Error().Err(err).Msg("Error closing dead letter writer")
+		}
+	}()
+
+	metrics.SetWorkers(config.Workers)
+	metrics.SetLagSource(newGroupLag(brokerAddress, topicName, groupID).Lag)
+
+	zlog.Logger.Info().Msgf("Starting %d workers, memory limit %d MB each", config.Workers, config.MemoryLimit>>20)
+
+	var wg sync.WaitGroup
+
+	for worker := 1; worker <= config.Workers; worker++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			runWorker(ctx, db, client, deadLetters, topicName, worker, config.MemoryLimit)
+		}()
+	}
 
+	wg.Wait()
+
+	zlog.Logger.Info().Msg("Shutting down kafka")
+}
+
+// runWorker подтверждает сообщение только после того, как фото обработано или задача
+// отправлена в очередь недоставленных. Если процесс упадет посреди обработки,
+// сообщение будет прочитано снова.
+func runWorker(ctx context.Context, db *dbpg.DB, client *minio.Client, deadLetters *kafka.Writer, topicName string, worker int, memoryLimit int64) {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:          []string{brokerAddress},
 		Topic:            topicName,
@@ -31,45 +78,107 @@ func StartConsumer(ctx context.Context, db *dbpg.DB, client *minio.Client, topic
 	})
 	defer func() {
 		if err := reader.Close(); err != nil {
-			log.Println(err)
+			zlog.Logger.Error().Err(err).Msgf("Error closing kafka reader of worker %d", worker)
 		}
 	}()
 
 	for {
-		select {
-		case <-ctx.Done():
-			zlog.Logger.Info().Msg("Shutting down kafka")
-			return
-		default:
-			photo, err := getMessage(reader)
-			if err != nil {
-				zlog.Logger.Err(err)
-				continue
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
 
-			err = photoProcessing.ProcessPhoto(client, photo, db)
-			if err != nil {
-				zlog.Logger.Err(err)
-			}
+			zlog.Logger.Error().Err(err).Msgf("Worker %d: error fetching message", worker)
+			continue
+		}
+
+		err = handleMessage(ctx, db, client, deadLetters, msg, memoryLimit)
+		if err != nil {
+			// Без подтверждения сообщение вернется после перезапуска или ребалансировки
+			zlog.Logger.Error().Err(err).Msgf("Message at partition %d offset %d is left uncommitted", msg.Partition, msg.Offset)
+			continue
+		}
+
+		err = reader.CommitMessages(ctx, msg)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Msgf("Error committing partition %d offset %d", msg.Partition, msg.Offset)
 		}
 	}
 }
 
-func getMessage(reader *kafka.Reader) (*repository.Photo, error) {
-	ctx := context.Background()
-
-	msg, err := reader.ReadMessage(ctx)
+// handleMessage обрабатывает задачу с повторами. Ошибка возвращается, только если задачу
+// не удалось ни выполнить, ни отправить в очередь недоставленных.
+func handleMessage(ctx context.Context, db *dbpg.DB, client *minio.Client, deadLetters *kafka.Writer, msg kafka.Message, memoryLimit int64) error {
+	photo, err := decodeMessage(msg)
 	if err != nil {
-		return nil, err
+		zlog.Logger.Error().Err(err).Msgf("Invalid message at offset %d", msg.Offset)
+		return sendDeadLetter(ctx, deadLetters, msg, err, 0)
 	}
 
+	done := metrics.StartProcessing()
+	attempt := 1
+
+	for {
+		err = photoProcessing.ProcessPhoto(client, photo, db, memoryLimit)
+		if err == nil {
+			done(metrics.ResultDone)
+			return nil
+		}
+
+		if photoProcessing.IsPermanent(err) || attempt >= maxAttempts {
+			break
+		}
+
+		delay := backoff(attempt)
+
+		zlog.Logger.Warn().Err(err).Msgf("Processing %s failed on attempt %d, retrying in %s", photo.UUID, attempt, delay)
+
+		metrics.IncRetries()
+
+		select {
+		case <-ctx.Done():
+			done(metrics.ResultCanceled)
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		attempt++
+	}
+
+	done(metrics.ResultFailed)
+
+	zlog.Logger.Error().Err(err).Msgf("Processing %s failed after %d attempts", photo.UUID, attempt)
+
+	failErr := repository.FailPhoto(db, photo.UUID, err.Error())
+	if failErr != nil {
+		zlog.Logger.Error().Err(failErr).Msgf("Error marking %s as failed", photo.UUID)
+	}
+
+	return sendDeadLetter(ctx, deadLetters, msg, err, attempt)
+}
+
+func backoff(attempt int) time.Duration {
+	delay := retryDelay << (attempt - 1)
+	if delay <= 0 || delay > maxRetryWait {
+		return maxRetryWait
+	}
+
+	return delay
+}
+
+func decodeMessage(msg kafka.Message) (*repository.Photo, error) {
 	var photo repository.Photo
 
-	err = json.Unmarshal(msg.Value, &photo)
+	err := json.Unmarshal(msg.Value, &photo)
 	if err != nil {
 		return nil, err
 	}
 
+	if photo.UUID == nil {
+		return nil, errors.New("message has no photo uuid")
+	}
+
 	zlog.Logger.Info().Msg("Successfully processed message")
 
 	return &photo, nil
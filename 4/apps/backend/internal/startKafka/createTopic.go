@@ -8,14 +8,16 @@ import (
 	"github.com/wb-go/wbf/zlog"
 )
 
-func EnsureTopicExists(brokerAddress, topicName string) error {
+// EnsureTopicExists создает топик с нужным числом партиций, а у существующего топика
+// добавляет недостающие. Уменьшить число партиций Kafka не позволяет.
+func EnsureTopicExists(brokerAddress, topicName string, partitions int) error {
 	ctx := context.Background()
 
 	conn, err := kafka.DialLeader(ctx, "tcp", brokerAddress, topicName, 0)
 	if err == nil {
-		conn.Close()
+		defer conn.Close()
 		zlog.Logger.Info().Msgf("Topic %s is already exists", topicName)
-		return nil
+		return ensurePartitions(ctx, conn, brokerAddress, topicName, partitions)
 	}
 
 	zlog.Logger.Info().Msgf("Creating topic %s", topicName)
@@ -39,7 +41,7 @@ func EnsureTopicExists(brokerAddress, topicName string) error {
 	topicConfigs := []kafka.TopicConfig{
 		{
 			Topic:             topicName,
-			NumPartitions:     1,
+			NumPartitions:     partitions,
 			ReplicationFactor: 1,
 		},
 	}
@@ -52,3 +54,35 @@ func EnsureTopicExists(brokerAddress, topicName string) error {
 	zlog.Logger.Info().Msgf("Creating topic %s success", topicName)
 	return nil
 }
+
+// ensurePartitions добавляет партиции существующему топику. После этого часть ключей начнет
+// попадать в новые партиции, но каждое фото обрабатывается одним сообщением, так что порядок
+// между старыми и новыми сообщениями одного ключа не важен.
+func ensurePartitions(ctx context.Context, conn *kafka.Conn, brokerAddress, topicName string, partitions int) error {
+	existing, err := conn.ReadPartitions(topicName)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) >= partitions {
+		return nil
+	}
+
+	zlog.Logger.Info().Msgf("Increasing partitions of %s from %d to %d", topicName, len(existing), partitions)
+
+	client := &kafka.Client{Addr: kafka.TCP(brokerAddress)}
+
+	resp, err := client.CreatePartitions(ctx, &kafka.CreatePartitionsRequest{
+		Topics: []kafka.TopicPartitionsConfig{
+			{
+				Name:  topicName,
+				Count: int32(partitions),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return resp.Errors[topicName]
+}
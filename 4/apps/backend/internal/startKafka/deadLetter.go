@@ -0,0 +1,55 @@
+package startKafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// DeadLetterTopic возвращает топик для задач, которые не удалось обработать
+func DeadLetterTopic(topicName string) string {
+	return topicName + "-dlq"
+}
+
+// sendDeadLetter публикует исходное сообщение в очередь недоставленных с причиной в заголовках.
+// Пока публикация не удалась, исходное сообщение нельзя подтверждать, поэтому она повторяется
+// до успеха или остановки потребителя.
+func sendDeadLetter(ctx context.Context, writer *kafka.Writer, msg kafka.Message, cause error, attempts int) error {
+	deadLetter := kafka.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Time:  time.Now(),
+		Headers: append(msg.Headers,
+			kafka.Header{Key: "error", Value: []byte(cause.Error())},
+			kafka.Header{Key: "attempts", Value: []byte(strconv.Itoa(attempts))},
+			kafka.Header{Key: "original-topic", Value: []byte(msg.Topic)},
+			kafka.Header{Key: "original-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+			kafka.Header{Key: "original-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		),
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := writer.WriteMessages(ctx, deadLetter)
+		if err == nil {
+			zlog.Logger.Info().Msgf("Message at offset %d sent to %s", msg.Offset, writer.Topic)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff(attempt)
+
+		zlog.Logger.Error().Err(err).Msgf("Error sending message to %s, retrying in %s", writer.Topic, delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
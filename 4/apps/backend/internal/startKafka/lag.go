@@ -0,0 +1,114 @@
+package startKafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const lagTimeout = 5 * time.Second
+
+// groupLag считает отставание группы по данным брокера: сколько сообщений каждой партиции
+// записано после последнего подтвержденного. Так отставание растет, даже если все обработчики
+// заняты и ничего не читают, а партиции, ушедшие при ребалансировке, не зависают со старым значением.
+type groupLag struct {
+	client *kafka.Client
+	topic  string
+	group  string
+}
+
+func newGroupLag(brokerAddress, topic, group string) *groupLag {
+	return &groupLag{
+		client: &kafka.Client{Addr: kafka.TCP(brokerAddress), Timeout: lagTimeout},
+		topic:  topic,
+		group:  group,
+	}
+}
+
+// Lag возвращает отставание по всем партициям топика
+func (l *groupLag) Lag(ctx context.Context) (map[int]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, lagTimeout)
+	defer cancel()
+
+	partitions, err := l.partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]kafka.OffsetRequest, 0, 2*len(partitions))
+	for _, partition := range partitions {
+		requests = append(requests, kafka.FirstOffsetOf(partition), kafka.LastOffsetOf(partition))
+	}
+
+	offsets, err := l.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{l.topic: requests},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := l.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: l.group,
+		Topics:  map[string][]int{l.topic: partitions},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if committed.Error != nil {
+		return nil, committed.Error
+	}
+
+	// Если группа еще ничего не подтвердила, читать она начнет с первого сообщения
+	start := make(map[int]int64, len(partitions))
+	for _, partition := range committed.Topics[l.topic] {
+		if partition.Error == nil && partition.CommittedOffset >= 0 {
+			start[partition.Partition] = partition.CommittedOffset
+		}
+	}
+
+	lag := make(map[int]int64, len(partitions))
+
+	for _, partition := range offsets.Topics[l.topic] {
+		if partition.Error != nil {
+			return nil, fmt.Errorf("partition %d: %w", partition.Partition, partition.Error)
+		}
+
+		offset, ok := start[partition.Partition]
+		if !ok {
+			offset = partition.FirstOffset
+		}
+
+		lag[partition.Partition] = max(partition.LastOffset-offset, 0)
+	}
+
+	return lag, nil
+}
+
+func (l *groupLag) partitions(ctx context.Context) ([]int, error) {
+	metadata, err := l.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{l.topic}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, topic := range metadata.Topics {
+		if topic.Name != l.topic {
+			continue
+		}
+
+		if topic.Error != nil {
+			return nil, topic.Error
+		}
+
+		partitions := make([]int, 0, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			partitions = append(partitions, partition.ID)
+		}
+
+		return partitions, nil
+	}
+
+	return nil, fmt.Errorf("topic %s not found", l.topic)
+}
@@ -10,11 +10,13 @@ import (
 	"github.com/wb-go/wbf/zlog"
 )
 
+// StartProducer раскладывает сообщения по партициям по хешу ключа, так что задачи
+// одного фото всегда попадают в одну партицию и обрабатываются по порядку
 func StartProducer(topicName string) *kafka.Writer {
 	writer := &kafka.Writer{
 		Addr:     kafka.TCP("kafka:9092"),
 		Topic:    topicName,
-		Balancer: &kafka.LeastBytes{},
+		Balancer: &kafka.Hash{},
 	}
 
 	return writer
@@ -29,7 +31,7 @@ func SendMessage(photo *repository.Photo, writer *kafka.Writer) error {
 	ctx := context.Background()
 
 	err = writer.WriteMessages(ctx, kafka.Message{
-		Key:   []byte("photo"),
+		Key:   []byte(photo.UUID.String()),
 		Value: data,
 		Time:  time.Now(),
 	})
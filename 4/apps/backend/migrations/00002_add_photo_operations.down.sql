@@ -0,0 +1 @@
+ALTER TABLE photos DROP COLUMN IF EXISTS operations;
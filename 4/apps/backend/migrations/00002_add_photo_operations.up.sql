@@ -0,0 +1 @@
+ALTER TABLE photos ADD COLUMN operations JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,11 @@
+CREATE TABLE photo_variants (
+    photo_uuid UUID NOT NULL REFERENCES photos(uuid) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    object_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (photo_uuid, name)
+);
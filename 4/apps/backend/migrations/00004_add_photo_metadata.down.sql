@@ -0,0 +1,9 @@
+ALTER TABLE photos
+    DROP COLUMN IF EXISTS width,
+    DROP COLUMN IF EXISTS height,
+    DROP COLUMN IF EXISTS orientation,
+    DROP COLUMN IF EXISTS camera_make,
+    DROP COLUMN IF EXISTS camera_model,
+    DROP COLUMN IF EXISTS taken_at,
+    DROP COLUMN IF EXISTS has_gps,
+    DROP COLUMN IF EXISTS keep_metadata;
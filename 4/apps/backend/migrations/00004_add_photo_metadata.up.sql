@@ -0,0 +1,9 @@
+ALTER TABLE photos
+    ADD COLUMN width INT,
+    ADD COLUMN height INT,
+    ADD COLUMN orientation SMALLINT NOT NULL DEFAULT 1,
+    ADD COLUMN camera_make VARCHAR(100),
+    ADD COLUMN camera_model VARCHAR(100),
+    ADD COLUMN taken_at TIMESTAMP,
+    ADD COLUMN has_gps BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN keep_metadata BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,8 @@
+CREATE TABLE watermarks (
+    uuid UUID PRIMARY KEY,
+    object_key VARCHAR(255) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
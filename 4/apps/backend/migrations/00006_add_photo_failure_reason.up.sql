@@ -0,0 +1 @@
+ALTER TABLE photos ADD COLUMN failure_reason TEXT;